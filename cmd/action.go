@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type ActionType string
+
+const (
+	// ActionExpire removes the current version of a key by creating a delete marker.
+	ActionExpire ActionType = "expire"
+	// ActionDeleteVersion permanently removes a given version of a key.
+	ActionDeleteVersion ActionType = "delete-version"
+	// ActionAbortMultipartUpload aborts an incomplete multipart upload.
+	ActionAbortMultipartUpload ActionType = "abort-multipart-upload"
+	// ActionTransition copies a version of a key to another bucket. When the
+	// source is to be removed, the deletion is planned as a separate action.
+	ActionTransition ActionType = "transition"
+	// ActionSkip records a deletion which is not applied because the version is
+	// protected by Object Lock.
+	ActionSkip ActionType = "skip"
+)
+
+// Reason is the rule action responsible for a planned Action. Its value is also
+// used as the tag of the log lines.
+type Reason string
+
+const (
+	ReasonExpiration                Reason = "expiration"
+	ReasonNoncurrentDays            Reason = "non current days"
+	ReasonNewerNoncurrentVersions   Reason = "newer non current versions"
+	ReasonExpiredObjectDeleteMarker Reason = "expire delete marker"
+	ReasonAbortMultipartUpload      Reason = "abort multipart upload"
+	ReasonTransition                Reason = "transition"
+	ReasonNoncurrentTransition      Reason = "non current version transition"
+	ReasonRetention                 Reason = "object lock retention"
+	ReasonLegalHold                 Reason = "object lock legal hold"
+	ReasonLockUnknown               Reason = "object lock unknown"
+)
+
+// transition reports whether the action is part of a transition.
+func (r Reason) transition() bool {
+	return r == ReasonTransition || r == ReasonNoncurrentTransition
+}
+
+// Action is a single change planned on the bucket by a rule.
+type Action struct {
+	Type      ActionType
+	Key       string
+	VersionId string
+	UploadId  string
+	RuleID    string
+	Reason    Reason
+	// TargetZone, TargetBucket and TargetKey are the destination of a transition.
+	// An empty zone is the zone of the bucket.
+	TargetZone   string
+	TargetBucket string
+	TargetKey    string
+}
+
+func (a Action) String() string {
+	switch a.Type {
+	case ActionAbortMultipartUpload:
+		return fmt.Sprintf("[%s] rule: %s, key: %s, upload %s", a.Reason, a.RuleID, a.Key, a.UploadId)
+	case ActionTransition:
+		return fmt.Sprintf("[%s] rule: %s, key: %s, version %s to %s", a.Reason, a.RuleID, a.Key, a.VersionId, a.target())
+	}
+	return fmt.Sprintf("[%s] rule: %s, key: %s, version %s", a.Reason, a.RuleID, a.Key, a.VersionId)
+}
+
+// ActionHandler receives the actions planned by the rules, in order. Flush is
+// called once the bucket has been fully walked.
+type ActionHandler interface {
+	Handle(Action)
+	Flush()
+}
+
+// target describes the destination of a transition.
+func (a Action) target() string {
+	if a.TargetZone == "" {
+		return fmt.Sprintf("%s/%s", a.TargetBucket, a.TargetKey)
+	}
+	return fmt.Sprintf("%s:%s/%s", a.TargetZone, a.TargetBucket, a.TargetKey)
+}
+
+// Plan records the actions without applying them.
+type Plan struct {
+	Actions []Action
+}
+
+func (p *Plan) Handle(action Action) {
+	p.Actions = append(p.Actions, action)
+}
+
+func (p *Plan) Flush() {}
+
+// actionSet collects the actions planned by the rules on the same versions and
+// keeps a single deletion per version. Like on S3, a permanent deletion takes
+// precedence over a transition, which takes precedence over an expiration:
+// transitions to distinct targets are all kept, ahead of the expiration.
+type actionSet struct {
+	Actions []Action
+}
+
+func (s *actionSet) Handle(action Action) {
+	if action.Type == ActionTransition && s.permanentlyDeleted(action) {
+		return
+	}
+	if action.Type == ActionDeleteVersion && !action.Reason.transition() {
+		s.Actions = slices.DeleteFunc(s.Actions, func(planned Action) bool {
+			return planned.Type == ActionTransition && planned.Key == action.Key && planned.VersionId == action.VersionId
+		})
+	}
+
+	for i, planned := range s.Actions {
+		if planned.Key != action.Key || planned.VersionId != action.VersionId {
+			continue
+		}
+		switch {
+		case action.Type == ActionTransition && planned.Type == ActionTransition:
+			if planned.target() == action.target() {
+				return
+			}
+		case action.Type == ActionTransition:
+			// The version is copied before being removed
+			s.Actions = slices.Insert(s.Actions, i, action)
+			return
+		case planned.Type == ActionTransition:
+		default:
+			if deletionPrecedence(action) > deletionPrecedence(planned) {
+				s.Actions[i] = action
+			}
+			return
+		}
+	}
+	s.Actions = append(s.Actions, action)
+}
+
+// permanentlyDeleted reports whether a rule other than a transition already
+// removes the version targeted by the action for good.
+func (s *actionSet) permanentlyDeleted(action Action) bool {
+	for _, planned := range s.Actions {
+		if planned.Key == action.Key && planned.VersionId == action.VersionId &&
+			planned.Type == ActionDeleteVersion && !planned.Reason.transition() {
+			return true
+		}
+	}
+	return false
+}
+
+// deletionPrecedence ranks the deletions of a same version. A deletion planned by
+// a transition is only applied once the copy succeeded, so the deletions planned
+// by the other rules are preferred.
+func deletionPrecedence(action Action) int {
+	precedence := 0
+	if action.Type == ActionDeleteVersion {
+		precedence += 2
+	}
+	if !action.Reason.transition() {
+		precedence++
+	}
+	return precedence
+}
+
+func (s *actionSet) Flush() {}
+
+// maxDeleteObjects is the maximum number of keys accepted by a DeleteObjects call.
+const maxDeleteObjects = 1000
+
+// clientHandler applies the actions on the bucket with a pool of workers, while
+// the caller keeps on listing. All the actions on a given key are sent to the
+// same worker so that they are applied in the order they were planned.
+type clientHandler struct {
+	client           S3Client
+	bucket           *string
+	concurrency      int
+	bypassGovernance bool
+	workers          []chan Action
+	wg               sync.WaitGroup
+	targets          *targetClients
+}
+
+func newClientHandler(client S3Client, bucket *string, options Options) *clientHandler {
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &clientHandler{
+		client:           client,
+		bucket:           bucket,
+		concurrency:      concurrency,
+		bypassGovernance: options.BypassGovernanceRetention,
+		targets:          &targetClients{source: client, newClient: options.TargetClient, clients: map[string]S3Client{}},
+	}
+}
+
+func (h *clientHandler) Handle(action Action) {
+	if h.workers == nil {
+		h.start()
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(action.Key))
+	h.workers[hash.Sum32()%uint32(len(h.workers))] <- action
+}
+
+// Flush waits for the workers to apply every pending action.
+func (h *clientHandler) Flush() {
+	if h.workers == nil {
+		return
+	}
+	for _, actions := range h.workers {
+		close(actions)
+	}
+	h.wg.Wait()
+	h.workers = nil
+}
+
+func (h *clientHandler) start() {
+	h.workers = make([]chan Action, h.concurrency)
+	for i := range h.workers {
+		h.workers[i] = make(chan Action, maxDeleteObjects)
+		w := &worker{
+			client:            h.client,
+			bucket:            h.bucket,
+			bypassGovernance:  h.bypassGovernance,
+			targets:           h.targets,
+			pendingKeys:       map[string]bool{},
+			failedTransitions: map[string]bool{},
+		}
+		h.wg.Add(1)
+		go func(actions chan Action) {
+			defer h.wg.Done()
+			w.run(actions)
+		}(h.workers[i])
+	}
+}
+
+// worker buffers deletions and sends them through DeleteObjects by batches of up
+// to maxDeleteObjects keys.
+type worker struct {
+	client            S3Client
+	bucket            *string
+	bypassGovernance  bool
+	targets           *targetClients
+	pending           []Action
+	pendingKeys       map[string]bool
+	failedTransitions map[string]bool
+}
+
+func (w *worker) run(actions chan Action) {
+	for action := range actions {
+		w.handle(action)
+	}
+	w.flush()
+}
+
+func (w *worker) handle(action Action) {
+	switch action.Type {
+	case ActionExpire, ActionDeleteVersion:
+		// The source of a transition is only removed once it has been copied
+		if action.Reason.transition() && w.failedTransitions[objectId(&action.Key, &action.VersionId)] {
+			log.Printf("[%s] key: %s, version %s not removed, the copy failed\n", action.Reason, action.Key, action.VersionId)
+			return
+		}
+		// The objects of a DeleteObjects call are removed in no particular order:
+		// a delete marker is only expired once the versions it hides are gone.
+		if action.Reason == ReasonExpiredObjectDeleteMarker && w.pendingKeys[action.Key] {
+			w.flush()
+		}
+		w.pending = append(w.pending, action)
+		w.pendingKeys[action.Key] = true
+		if len(w.pending) == maxDeleteObjects {
+			w.flush()
+		}
+	case ActionAbortMultipartUpload:
+		_, err := w.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{Bucket: w.bucket, Key: &action.Key, UploadId: &action.UploadId})
+		if err != nil {
+			log.Printf("[%s] cannot abort upload %s", action.Reason, action.UploadId)
+		} else {
+			log.Printf("[%s] upload %s removed", action.Reason, action.UploadId)
+		}
+	case ActionSkip:
+		log.Printf("[%s] key: %s, version %s is protected, not removed\n", action.Reason, action.Key, action.VersionId)
+	case ActionTransition:
+		copied := false
+		target, err := w.targets.get(action.TargetZone)
+		if err == nil {
+			copied, err = transition(context.TODO(), w.client, w.bucket, target, action)
+		}
+		switch {
+		case err != nil:
+			w.failedTransitions[objectId(&action.Key, &action.VersionId)] = true
+			log.Printf("[%s] key: %s, version %s cannot be copied to %s: %v\n", action.Reason, action.Key, action.VersionId, action.target(), err)
+		case copied:
+			log.Printf("[%s] key: %s, version %s copied to %s\n", action.Reason, action.Key, action.VersionId, action.target())
+		default:
+			log.Printf("[%s] key: %s, version %s already in %s, not copied\n", action.Reason, action.Key, action.VersionId, action.target())
+		}
+	}
+}
+
+func (w *worker) flush() {
+	if len(w.pending) == 0 {
+		return
+	}
+	batch := w.pending
+	w.pending = nil
+	w.pendingKeys = map[string]bool{}
+
+	objects := make([]types.ObjectIdentifier, 0, len(batch))
+	actions := make(map[string]Action, len(batch))
+	for _, action := range batch {
+		object := types.ObjectIdentifier{Key: aws.String(action.Key)}
+		// Expiration does not target a version so that a delete marker is created
+		if action.Type == ActionDeleteVersion {
+			object.VersionId = aws.String(action.VersionId)
+		}
+		objects = append(objects, object)
+		actions[objectId(object.Key, object.VersionId)] = action
+	}
+
+	output, err := w.client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		Bucket:                    w.bucket,
+		Delete:                    &types.Delete{Objects: objects},
+		BypassGovernanceRetention: w.bypassGovernance,
+	})
+	if err != nil {
+		for _, action := range batch {
+			logDeletion(action, err)
+		}
+		return
+	}
+
+	for _, deleted := range output.Deleted {
+		if action, ok := matchResult(actions, deleted.Key, deleted.VersionId); ok {
+			logDeletion(action, nil)
+		} else {
+			log.Printf("[delete] key: %s, version %s removed, not requested\n", aws.ToString(deleted.Key), aws.ToString(deleted.VersionId))
+		}
+	}
+	for _, e := range output.Errors {
+		err := fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+		if action, ok := matchResult(actions, e.Key, e.VersionId); ok {
+			logDeletion(action, err)
+		} else {
+			log.Printf("[delete] key: %s, version %s cannot be removed, not requested: %v\n", aws.ToString(e.Key), aws.ToString(e.VersionId), err)
+		}
+	}
+}
+
+// matchResult finds the action of an entry of the DeleteObjects result, and
+// forgets it. An expiration is requested without a version ID, while the result
+// may hold the one of the delete marker created: the action is then matched by
+// its key alone.
+func matchResult(actions map[string]Action, key, versionId *string) (Action, bool) {
+	id := objectId(key, versionId)
+	if action, ok := actions[id]; ok {
+		delete(actions, id)
+		return action, true
+	}
+	id = objectId(key, nil)
+	if action, ok := actions[id]; ok && action.Type == ActionExpire {
+		delete(actions, id)
+		return action, true
+	}
+	return Action{}, false
+}
+
+// objectId identifies an entry of a DeleteObjects call by its key and version.
+func objectId(key, versionId *string) string {
+	return aws.ToString(key) + "\x00" + aws.ToString(versionId)
+}
+
+func logDeletion(action Action, err error) {
+	if err != nil {
+		log.Printf("[%s] key: %s, version %s cannot be removed: %v\n", action.Reason, action.Key, action.VersionId, err)
+	} else {
+		log.Printf("[%s] key: %s, version %s removed\n", action.Reason, action.Key, action.VersionId)
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// LoadBucketConfig reads the lifecycle configuration stored on the bucket with
+// GetBucketLifecycleConfiguration. It goes through the same checks as a
+// configuration file.
+func LoadBucketConfig(client S3Client, bucket string) (*config.BucketLifecycleConfiguration, error) {
+	output, err := client.GetBucketLifecycleConfiguration(context.TODO(), &s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return nil, err
+	}
+
+	blc, err := FromLifecycleRules(output.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(blc); err != nil {
+		return nil, err
+	}
+
+	return blc, nil
+}
+
+// FromLifecycleRules translates the S3 lifecycle rules into a configuration.
+// S3 transitions target a storage class rather than a bucket, so they cannot be
+// translated.
+func FromLifecycleRules(rules []types.LifecycleRule) (*config.BucketLifecycleConfiguration, error) {
+	blc := &config.BucketLifecycleConfiguration{Rules: make([]config.Rule, 0, len(rules))}
+	for _, rule := range rules {
+		id := aws.ToString(rule.ID)
+		if len(rule.Transitions) > 0 || len(rule.NoncurrentVersionTransitions) > 0 {
+			return nil, fmt.Errorf("rule %s: transitions to a storage class are not supported", id)
+		}
+
+		r := config.Rule{
+			ID:     id,
+			Status: string(rule.Status),
+			Filter: fromLifecycleRuleFilter(rule.Filter),
+		}
+		// Prefix is the deprecated way to filter the objects of a rule
+		if r.Filter == nil && rule.Prefix != nil {
+			r.Filter = &config.Filter{Prefix: rule.Prefix}
+		}
+
+		if expiration := rule.Expiration; expiration != nil {
+			r.Expiration = &config.Expiration{ExpiredObjectDeleteMarker: expiration.ExpiredObjectDeleteMarker}
+			if expiration.Date != nil {
+				r.Expiration.Date = &config.Date{Time: *expiration.Date}
+			} else if expiration.Days > 0 {
+				r.Expiration.Days = aws.Int(int(expiration.Days))
+			}
+		}
+
+		if expiration := rule.NoncurrentVersionExpiration; expiration != nil {
+			r.NoncurrentVersionExpiration = &config.NoncurrentVersionExpiration{}
+			if expiration.NoncurrentDays > 0 {
+				r.NoncurrentVersionExpiration.NoncurrentDays = aws.Int(int(expiration.NoncurrentDays))
+			}
+			if expiration.NewerNoncurrentVersions > 0 {
+				r.NoncurrentVersionExpiration.NewerNoncurrentVersions = aws.Int(int(expiration.NewerNoncurrentVersions))
+			}
+		}
+
+		if abort := rule.AbortIncompleteMultipartUpload; abort != nil {
+			r.AbortIncompleteMultipartUpload = &config.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int(int(abort.DaysAfterInitiation)),
+			}
+		}
+
+		blc.Rules = append(blc.Rules, r)
+	}
+	return blc, nil
+}
+
+func fromLifecycleRuleFilter(filter types.LifecycleRuleFilter) *config.Filter {
+	switch f := filter.(type) {
+	case *types.LifecycleRuleFilterMemberPrefix:
+		// An empty prefix matches every object
+		if f.Value == "" {
+			return nil
+		}
+		return &config.Filter{Prefix: aws.String(f.Value)}
+	case *types.LifecycleRuleFilterMemberTag:
+		return &config.Filter{Tag: fromTag(f.Value)}
+	case *types.LifecycleRuleFilterMemberObjectSizeGreaterThan:
+		return &config.Filter{ObjectSizeGreaterThan: aws.Int64(f.Value)}
+	case *types.LifecycleRuleFilterMemberObjectSizeLessThan:
+		return &config.Filter{ObjectSizeLessThan: aws.Int64(f.Value)}
+	case *types.LifecycleRuleFilterMemberAnd:
+		and := &config.AndFilter{Prefix: f.Value.Prefix}
+		if f.Value.ObjectSizeGreaterThan > 0 {
+			and.ObjectSizeGreaterThan = aws.Int64(f.Value.ObjectSizeGreaterThan)
+		}
+		if f.Value.ObjectSizeLessThan > 0 {
+			and.ObjectSizeLessThan = aws.Int64(f.Value.ObjectSizeLessThan)
+		}
+		for _, tag := range f.Value.Tags {
+			and.Tags = append(and.Tags, *fromTag(tag))
+		}
+		return &config.Filter{And: and}
+	}
+	return nil
+}
+
+func fromTag(tag types.Tag) *config.Tag {
+	return &config.Tag{Key: aws.ToString(tag.Key), Value: aws.ToString(tag.Value)}
+}
@@ -0,0 +1,104 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+	bconfig "github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+func TestFromLifecycleRules(t *testing.T) {
+	blc, err := cmd.FromLifecycleRules([]types.LifecycleRule{{
+		ID:     aws.String("RULE001"),
+		Status: types.ExpirationStatusEnabled,
+		Filter: &types.LifecycleRuleFilterMemberPrefix{Value: "documents/"},
+		Expiration: &types.LifecycleExpiration{
+			Days:                      10,
+			ExpiredObjectDeleteMarker: true,
+		},
+		NoncurrentVersionExpiration: &types.NoncurrentVersionExpiration{NewerNoncurrentVersions: 20},
+		AbortIncompleteMultipartUpload: &types.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: 7,
+		},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &bconfig.BucketLifecycleConfiguration{Rules: []bconfig.Rule{{
+		ID:     "RULE001",
+		Status: "Enabled",
+		Filter: &bconfig.Filter{Prefix: aws.String("documents/")},
+		Expiration: &bconfig.Expiration{
+			Days:                      aws.Int(10),
+			ExpiredObjectDeleteMarker: true,
+		},
+		NoncurrentVersionExpiration: &bconfig.NoncurrentVersionExpiration{NewerNoncurrentVersions: aws.Int(20)},
+		AbortIncompleteMultipartUpload: &bconfig.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int(7),
+		},
+	}}}, blc)
+}
+
+func TestFromLifecycleRulesAndFilter(t *testing.T) {
+	blc, err := cmd.FromLifecycleRules([]types.LifecycleRule{{
+		ID:     aws.String("RULE001"),
+		Status: types.ExpirationStatusDisabled,
+		Filter: &types.LifecycleRuleFilterMemberAnd{Value: types.LifecycleRuleAndOperator{
+			Prefix:             aws.String("documents/"),
+			ObjectSizeLessThan: 1024,
+			Tags:               []types.Tag{{Key: aws.String("lifecycle"), Value: aws.String("ephemeral")}},
+		}},
+		NoncurrentVersionExpiration: &types.NoncurrentVersionExpiration{NoncurrentDays: 1},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, "Disabled", blc.Rules[0].Status)
+	require.Equal(t, &bconfig.Filter{And: &bconfig.AndFilter{
+		Prefix:             aws.String("documents/"),
+		ObjectSizeLessThan: aws.Int64(1024),
+		Tags:               []bconfig.Tag{{Key: "lifecycle", Value: "ephemeral"}},
+	}}, blc.Rules[0].Filter)
+	require.Equal(t, &bconfig.NoncurrentVersionExpiration{NoncurrentDays: aws.Int(1)}, blc.Rules[0].NoncurrentVersionExpiration)
+}
+
+func TestFromLifecycleRulesDeprecatedPrefix(t *testing.T) {
+	blc, err := cmd.FromLifecycleRules([]types.LifecycleRule{{
+		ID:         aws.String("RULE001"),
+		Status:     types.ExpirationStatusEnabled,
+		Prefix:     aws.String("documents/"),
+		Expiration: &types.LifecycleExpiration{Date: aws.Time(t0)},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &bconfig.Filter{Prefix: aws.String("documents/")}, blc.Rules[0].Filter)
+	require.Equal(t, &bconfig.Expiration{Date: &bconfig.Date{Time: t0}}, blc.Rules[0].Expiration)
+}
+
+func TestFromLifecycleRulesTransition(t *testing.T) {
+	_, err := cmd.FromLifecycleRules([]types.LifecycleRule{{
+		ID:          aws.String("RULE001"),
+		Status:      types.ExpirationStatusEnabled,
+		Transitions: []types.Transition{{Days: 30, StorageClass: types.TransitionStorageClassGlacier}},
+	}})
+	require.Error(t, err)
+}
+
+func TestLoadBucketConfigRuleID(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	_, err := client.PutBucketLifecycleConfiguration(context.TODO(), &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: &bucket,
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{{
+			ID:         aws.String("expire-old-logs"),
+			Status:     types.ExpirationStatusEnabled,
+			Filter:     &types.LifecycleRuleFilterMemberPrefix{Value: "logs/"},
+			Expiration: &types.LifecycleExpiration{Days: 30},
+		}}},
+	})
+	require.NoError(t, err)
+
+	blc, err := cmd.LoadBucketConfig(client, bucket)
+	require.NoError(t, err)
+	require.Equal(t, "expire-old-logs", blc.Rules[0].ID)
+}
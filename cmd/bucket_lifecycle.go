@@ -2,16 +2,14 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
 	"log"
-	"os"
+	"slices"
 	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
-	"github.com/go-playground/validator/v10"
 
 	"github.com/exoscale/sos-client-bucket-lifecycle/config"
 )
@@ -22,6 +20,7 @@ type Version struct {
 	LastModified time.Time
 	VersionId    string
 	DeleteMarker bool
+	Size         int64
 }
 
 func ToVersions(output *s3.ListObjectVersionsOutput) []Version {
@@ -33,6 +32,7 @@ func ToVersions(output *s3.ListObjectVersionsOutput) []Version {
 			LastModified: *version.LastModified,
 			VersionId:    *version.VersionId,
 			DeleteMarker: false,
+			Size:         version.Size,
 		})
 	}
 
@@ -48,6 +48,27 @@ func ToVersions(output *s3.ListObjectVersionsOutput) []Version {
 	return versions
 }
 
+// NullVersionId is the version ID of the objects written while versioning was
+// not enabled on the bucket.
+const NullVersionId = "null"
+
+// ObjectsToVersions converts the objects of a bucket which has never been
+// versioned: each of them is the latest and only version of its key.
+func ObjectsToVersions(output *s3.ListObjectsV2Output) []Version {
+	versions := make([]Version, 0, len(output.Contents))
+	for _, object := range output.Contents {
+		versions = append(versions, Version{
+			Key:          *object.Key,
+			IsLatest:     true,
+			LastModified: *object.LastModified,
+			VersionId:    NullVersionId,
+			DeleteMarker: false,
+			Size:         object.Size,
+		})
+	}
+	return versions
+}
+
 func SortVersions(versions []Version) []Version {
 	sort.SliceStable(versions, func(i, j int) bool {
 		if versions[i].Key < versions[j].Key {
@@ -55,7 +76,10 @@ func SortVersions(versions []Version) []Version {
 		} else if versions[i].Key > versions[j].Key {
 			return false
 		}
-		return versions[i].LastModified.After(versions[j].LastModified)
+		if !versions[i].LastModified.Equal(versions[j].LastModified) {
+			return versions[i].LastModified.After(versions[j].LastModified)
+		}
+		return versions[i].IsLatest && !versions[j].IsLatest
 	})
 
 	return versions
@@ -65,25 +89,43 @@ func AgeInDays(now, lastModified time.Time) int {
 	return int(now.Sub(lastModified).Hours() / 24)
 }
 
-func applyAbortIncompleteMultipartUpload(client *s3.Client, bucket *string, rule config.Rule) error {
-	if rule.AbortIncompleteMultipartUpload != nil {
-		paginator := s3.NewListMultipartUploadsPaginator(client, &s3.ListMultipartUploadsInput{Bucket: bucket})
-		log.Printf("[abort multipart upload] listing multipart uploads")
-		for paginator.HasMorePages() {
-			out, err := paginator.NextPage(context.TODO())
-			if err != nil {
-				return err
-			}
+// NoncurrentSince returns when the version at index i of a key history, newest
+// first, became noncurrent: that is when its successor was created.
+func NoncurrentSince(versions []Version, i int) time.Time {
+	if i == 0 {
+		return versions[i].LastModified
+	}
+	return versions[i-1].LastModified
+}
+
+func applyAbortIncompleteMultipartUpload(client S3Client, bucket *string, rules []config.Rule, now time.Time, handler ActionHandler) error {
+	aborting := false
+	for _, rule := range rules {
+		aborting = aborting || rule.AbortIncompleteMultipartUpload != nil
+	}
+	if !aborting {
+		return nil
+	}
+
+	paginator := s3.NewListMultipartUploadsPaginator(client, &s3.ListMultipartUploadsInput{Bucket: bucket})
+	log.Printf("[abort multipart upload] listing multipart uploads")
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
 
-			for _, upload := range out.Uploads {
-				age := AgeInDays(time.Now(), *upload.Initiated)
-				if age >= *rule.AbortIncompleteMultipartUpload.DaysAfterInitiation {
-					_, err := client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{Bucket: bucket, Key: upload.Key, UploadId: upload.UploadId})
-					if err != nil {
-						log.Printf("[abort multipart upload] cannot abort upload %s", *upload.UploadId)
-					} else {
-						log.Printf("[abort multipart upload] upload %s removed", *upload.UploadId)
-					}
+		for _, upload := range out.Uploads {
+			age := AgeInDays(now, *upload.Initiated)
+			// An upload is aborted once, by the first rule targeting it
+			for _, rule := range rules {
+				// Multipart uploads have no tags
+				if rule.AbortIncompleteMultipartUpload != nil &&
+					MatchPrefix(rule.Filter, *upload.Key) &&
+					!HasTagFilter(rule.Filter) &&
+					age >= *rule.AbortIncompleteMultipartUpload.DaysAfterInitiation {
+					handler.Handle(Action{Type: ActionAbortMultipartUpload, Key: *upload.Key, UploadId: *upload.UploadId, RuleID: rule.ID, Reason: ReasonAbortMultipartUpload})
+					break
 				}
 			}
 		}
@@ -91,152 +133,392 @@ func applyAbortIncompleteMultipartUpload(client *s3.Client, bucket *string, rule
 	return nil
 }
 
-func applyExpiration(client *s3.Client, bucket *string, rule config.Rule, version Version, age int) bool {
-	if rule.Expiration != nil && rule.Expiration.Days != nil && version.IsLatest && !version.DeleteMarker {
-		if age >= *rule.Expiration.Days {
-			_, err := client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: bucket, Key: &version.Key})
-			if err != nil {
-				log.Printf("[expiration] key: %s, version %s cannot be removed\n", version.Key, version.VersionId)
-			} else {
-				log.Printf("[expiration] key: %s, version %s removed\n", version.Key, version.VersionId)
-			}
+func (p *Planner) applyExpiration(rule config.Rule, version Version, age int, handler ActionHandler) bool {
+	if rule.Expiration != nil && version.IsLatest && !version.DeleteMarker {
+		if ((rule.Expiration.Days != nil && age >= *rule.Expiration.Days) ||
+			(rule.Expiration.Date != nil && !p.today.Before(rule.Expiration.Date.Time))) &&
+			p.match(rule, version) {
+			handler.Handle(Action{Type: ActionExpire, Key: version.Key, VersionId: version.VersionId, RuleID: rule.ID, Reason: ReasonExpiration})
 			return true
 		}
 	}
 	return false
 }
 
-func applyNoncurrentVersionExpiration(client *s3.Client, bucket *string, rule config.Rule, version Version, age int, nbVersions int) {
-	if rule.NoncurrentVersionExpiration != nil {
-		if rule.NoncurrentVersionExpiration.NoncurrentDays != nil && age >= *rule.NoncurrentVersionExpiration.NoncurrentDays {
-			_, err := client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: bucket, Key: &version.Key, VersionId: &version.VersionId})
-			if err != nil {
-				log.Printf("[non current days] key: %s, version %s cannot be removed\n", version.Key, version.VersionId)
-			} else {
-				log.Printf("[non current days] key: %s, version %s removed\n", version.Key, version.VersionId)
-			}
-		} else if rule.NoncurrentVersionExpiration.NewerNoncurrentVersions != nil && nbVersions > *rule.NoncurrentVersionExpiration.NewerNoncurrentVersions {
-			_, err := client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: bucket, Key: &version.Key, VersionId: &version.VersionId})
-			if err != nil {
-				log.Printf("[newer non current versions] key: %s, version %s cannot be removed\n", version.Key, version.VersionId)
-			} else {
-				log.Printf("[newer non current versions] key: %s, version %s removed\n", version.Key, version.VersionId)
-			}
+// applyTransitions plans the copy of the latest version of a key to the targets
+// of the rule. It reports whether the version is also removed.
+func (p *Planner) applyTransitions(rule config.Rule, version Version, age int, handler ActionHandler) bool {
+	if len(rule.Transitions) == 0 || !version.IsLatest || version.DeleteMarker {
+		return false
+	}
+
+	deleted := false
+	for _, transition := range rule.Transitions {
+		if age < *transition.Days || !p.match(rule, version) {
+			continue
+		}
+		handler.Handle(Action{
+			Type:         ActionTransition,
+			Key:          version.Key,
+			VersionId:    version.VersionId,
+			RuleID:       rule.ID,
+			Reason:       ReasonTransition,
+			TargetZone:   transition.Zone,
+			TargetBucket: transition.Bucket,
+			TargetKey:    version.Key,
+		})
+		if transition.DeleteSource && !deleted {
+			handler.Handle(Action{Type: ActionExpire, Key: version.Key, VersionId: version.VersionId, RuleID: rule.ID, Reason: ReasonTransition})
+			deleted = true
 		}
 	}
+	return deleted
 }
 
-func applyRule(client *s3.Client, bucket *string, rule config.Rule) error {
-	versioning, err := client.GetBucketVersioning(context.Background(), &s3.GetBucketVersioningInput{Bucket: bucket})
-	if err != nil {
-		return err
+// NoncurrentTargetKey is the key under which a noncurrent version is archived.
+func NoncurrentTargetKey(key, versionId string) string {
+	return key + "/" + versionId
+}
+
+// applyNoncurrentVersionTransitions plans the move of a noncurrent version to the
+// targets of the rule: it is copied under a key including its version ID, then
+// removed.
+func (p *Planner) applyNoncurrentVersionTransitions(rule config.Rule, version Version, noncurrentAge int, handler ActionHandler) {
+	// Delete markers have no content to archive
+	if len(rule.NoncurrentVersionTransitions) == 0 || version.IsLatest || version.DeleteMarker {
+		return
 	}
 
-	if versioning.Status != types.BucketVersioningStatusEnabled {
-		log.Fatalf("%s is not a versioned bucket", *bucket)
+	planned := false
+	for _, transition := range rule.NoncurrentVersionTransitions {
+		if noncurrentAge < *transition.NoncurrentDays || !p.match(rule, version) {
+			continue
+		}
+		handler.Handle(Action{
+			Type:         ActionTransition,
+			Key:          version.Key,
+			VersionId:    version.VersionId,
+			RuleID:       rule.ID,
+			Reason:       ReasonNoncurrentTransition,
+			TargetZone:   transition.Zone,
+			TargetBucket: transition.Bucket,
+			TargetKey:    NoncurrentTargetKey(version.Key, version.VersionId),
+		})
+		planned = true
+	}
+	if planned {
+		handler.Handle(Action{Type: ActionDeleteVersion, Key: version.Key, VersionId: version.VersionId, RuleID: rule.ID, Reason: ReasonNoncurrentTransition})
 	}
+}
 
-	var previousLatest Version
-	var currentKey string
-	var nbVersions int
+func (p *Planner) applyNoncurrentVersionExpiration(rule config.Rule, version Version, noncurrentAge int, nbVersions int, handler ActionHandler) {
+	expiration := rule.NoncurrentVersionExpiration
+	if expiration == nil || (expiration.NoncurrentDays == nil && expiration.NewerNoncurrentVersions == nil) {
+		return
+	}
+	// When both are set, a version is only removed once it is old enough and not
+	// among the newest noncurrent versions to keep.
+	if expiration.NoncurrentDays != nil && noncurrentAge < *expiration.NoncurrentDays {
+		return
+	}
+	if expiration.NewerNoncurrentVersions != nil && nbVersions <= *expiration.NewerNoncurrentVersions {
+		return
+	}
+	if !p.match(rule, version) {
+		return
+	}
 
-	expireObjectDeleteMarker := func(version *Version) {
-		if rule.Expiration != nil &&
-			rule.Expiration.ExpiredObjectDeleteMarker &&
-			previousLatest.DeleteMarker &&
-			previousLatest.IsLatest && (version == nil || version.Key != previousLatest.Key) && nbVersions == 0 {
-			_, err := client.DeleteObject(context.Background(), &s3.DeleteObjectInput{Bucket: bucket, Key: &previousLatest.Key, VersionId: &previousLatest.VersionId})
-			if err != nil {
-				log.Printf("[expire delete marker] key: %s, version %s cannot be removed\n", previousLatest.Key, previousLatest.VersionId)
-			} else {
-				log.Printf("[expire delete marker] key: %s, version %s removed\n", previousLatest.Key, previousLatest.VersionId)
-			}
-		}
+	reason := ReasonNewerNoncurrentVersions
+	if expiration.NoncurrentDays != nil {
+		reason = ReasonNoncurrentDays
 	}
+	handler.Handle(Action{Type: ActionDeleteVersion, Key: version.Key, VersionId: version.VersionId, RuleID: rule.ID, Reason: reason})
+}
 
-	if applyAbortIncompleteMultipartUpload(client, bucket, rule) != nil {
-		return err
+func (p *Planner) expireObjectDeleteMarker(rule config.Rule, versions []Version, handler ActionHandler) {
+	// Only a delete marker without any noncurrent version is expired
+	latest := versions[0]
+	if rule.Expiration != nil &&
+		rule.Expiration.ExpiredObjectDeleteMarker &&
+		len(versions) == 1 &&
+		latest.IsLatest &&
+		latest.DeleteMarker &&
+		p.match(rule, latest) {
+		handler.Handle(Action{Type: ActionDeleteVersion, Key: latest.Key, VersionId: latest.VersionId, RuleID: rule.ID, Reason: ReasonExpiredObjectDeleteMarker})
 	}
+}
 
-	paginator := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{Bucket: bucket})
-	for paginator.HasMorePages() {
-		output, err := paginator.NextPage(context.TODO())
+// TagFetcher returns the tags of a version.
+type TagFetcher func(version Version) (map[string]string, error)
+
+// Planner plans the actions of the rules on the version history of each key.
+type Planner struct {
+	Rules []config.Rule
+	// Versioning is the versioning status of the bucket, empty if versioning has
+	// never been enabled on it.
+	Versioning types.BucketVersioningStatus
+	// FetchTags is only called for the versions checked against a rule filtering
+	// on tags. When nil, such rules never match.
+	FetchTags TagFetcher
+	// FetchLock is called for the versions to remove for good when Object Lock is
+	// enabled on the bucket. When nil, no version is protected.
+	FetchLock LockFetcher
+	// BypassGovernance allows the removal of the versions under a governance
+	// retention.
+	BypassGovernance bool
+	// Clock dates the planning, time.Now when nil.
+	Clock Clock
+
+	tags  map[string]map[string]string
+	today time.Time
+}
+
+// match reports whether a version is targeted by the filter of the rule. The tags
+// are fetched once per version, and only when the rule filters on them.
+func (p *Planner) match(rule config.Rule, version Version) bool {
+	if !MatchFilter(rule.Filter, version) {
+		return false
+	}
+	if !HasTagFilter(rule.Filter) {
+		return true
+	}
+	// Delete markers have no tags
+	if version.DeleteMarker || p.FetchTags == nil {
+		return false
+	}
+
+	tags, ok := p.tags[version.VersionId]
+	if !ok {
+		var err error
+		tags, err = p.FetchTags(version)
 		if err != nil {
-			return err
+			log.Printf("[tags] key: %s, version %s cannot get tags: %v", version.Key, version.VersionId, err)
+			return false
 		}
+		p.tags[version.VersionId] = tags
+	}
+	return MatchTags(rule.Filter, tags)
+}
 
-		versions := SortVersions(ToVersions(output))
+// expirationKeepsVersion reports whether the expiration of the latest version
+// turns it into a noncurrent version. When versioning is suspended, the null
+// version is replaced by the delete marker.
+func (p *Planner) expirationKeepsVersion(version Version) bool {
+	switch p.Versioning {
+	case types.BucketVersioningStatusEnabled:
+		return true
+	case types.BucketVersioningStatusSuspended:
+		return version.VersionId != NullVersionId
+	default:
+		return false
+	}
+}
 
-		for _, version := range versions {
-			expireObjectDeleteMarker(&version)
+// applyRule plans the actions of a rule on the version history of a key, newest first.
+func (p *Planner) applyRule(rule config.Rule, versions []Version, handler ActionHandler) {
+	p.expireObjectDeleteMarker(rule, versions, handler)
 
-			if currentKey != "" && version.Key != currentKey {
-				nbVersions = 0
-			}
-			if version.IsLatest {
-				previousLatest = version
-			}
-			if currentKey != "" && version.Key == currentKey && !version.IsLatest {
-				nbVersions++
-			}
-			currentKey = version.Key
+	var nbVersions int
+	for i, version := range versions {
+		if !version.IsLatest {
+			nbVersions++
+		}
 
-			age := AgeInDays(time.Now(), version.LastModified)
-			// Expiration is only applied on the latest version of the key.
-			// If applied, creates an additional non-current version
-			if applyExpiration(client, bucket, rule, version, age) {
-				nbVersions++
-			}
+		age := AgeInDays(p.today, version.LastModified)
+		// Expiration and transitions are only applied on the latest version of the key.
+		// If it is removed, creates an additional non-current version
+		expired := p.applyExpiration(rule, version, age, handler)
+		moved := p.applyTransitions(rule, version, age, handler)
+		if (expired || moved) && p.expirationKeepsVersion(version) {
+			nbVersions++
+		}
 
-			// XXX: This is not taking into account the versions created by the Expiration, which
-			// is fine.
-			if !version.IsLatest {
-				applyNoncurrentVersionExpiration(client, bucket, rule, version, age, nbVersions)
-			}
+		// XXX: This is not taking into account the versions created by the Expiration, which
+		// is fine.
+		if !version.IsLatest {
+			noncurrentAge := AgeInDays(p.today, NoncurrentSince(versions, i))
+			p.applyNoncurrentVersionTransitions(rule, version, noncurrentAge, handler)
+			p.applyNoncurrentVersionExpiration(rule, version, noncurrentAge, nbVersions, handler)
 		}
 	}
-	expireObjectDeleteMarker(nil)
-
-	return nil
 }
 
-func LoadConfig(configPath string) (*config.BucketLifecycleConfiguration, error) {
-	// Open our jsonFile
-	jsonFile, err := os.Open(configPath)
-	// if we os.Open returns an error then handle it
-	if err != nil {
-		return nil, err
+// PlanVersions returns the actions planned by the rules on the version history of
+// a key, newest first, keeping a single action per version.
+func (p *Planner) PlanVersions(versions []Version) []Action {
+	// The tags are cached for the versions of the current key only
+	p.tags = map[string]map[string]string{}
+	p.today = p.Clock.now()
+	planned := &actionSet{}
+	for _, rule := range p.Rules {
+		p.applyRule(rule, versions, planned)
 	}
+	if p.FetchLock != nil {
+		p.skipProtected(versions, planned.Actions)
+	}
+	return planned.Actions
+}
 
-	defer jsonFile.Close()
+// skipProtected replaces the permanent deletions of the versions protected by
+// Object Lock. Delete markers cannot be locked.
+func (p *Planner) skipProtected(versions []Version, actions []Action) {
+	for i, action := range actions {
+		if action.Type != ActionDeleteVersion {
+			continue
+		}
+		idx := slices.IndexFunc(versions, func(version Version) bool { return version.VersionId == action.VersionId })
+		if idx < 0 || versions[idx].DeleteMarker {
+			continue
+		}
 
-	byteValue, _ := io.ReadAll(jsonFile)
+		lock, err := p.FetchLock(versions[idx])
+		if err != nil {
+			log.Printf("[object lock] key: %s, version %s cannot get the protection: %v", action.Key, action.VersionId, err)
+			actions[i].Type, actions[i].Reason = ActionSkip, ReasonLockUnknown
+			continue
+		}
+		if reason, protected := lock.protection(p.today, p.BypassGovernance); protected {
+			actions[i].Type, actions[i].Reason = ActionSkip, reason
+		}
+	}
+}
 
-	var blc config.BucketLifecycleConfiguration
+// applyRules walks the versions of the bucket once, applying every rule on the
+// history of each key.
+func applyRules(client S3Client, bucket *string, planner *Planner, handler ActionHandler) error {
+	if planner.Versioning == "" {
+		return applyRulesUnversioned(client, bucket, planner, handler)
+	}
 
-	_ = json.Unmarshal(byteValue, &blc)
+	iterator := NewVersionIterator(s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{Bucket: bucket}))
+	for {
+		versions, err := iterator.Next(context.TODO())
+		if err != nil {
+			return err
+		}
+		if versions == nil {
+			return nil
+		}
 
-	validate := validator.New()
-	if err := validate.Struct(blc); err != nil {
-		return nil, err
+		for _, action := range planner.PlanVersions(versions) {
+			handler.Handle(action)
+		}
 	}
+}
 
-	if err := blc.Validate(); err != nil {
-		return nil, err
+// applyRulesUnversioned walks the objects of a bucket which has never been
+// versioned: each object is the only version of its key and is removed for good
+// when expired.
+func applyRulesUnversioned(client S3Client, bucket *string, planner *Planner, handler ActionHandler) error {
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: bucket})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		for _, version := range ObjectsToVersions(output) {
+			for _, action := range planner.PlanVersions([]Version{version}) {
+				handler.Handle(action)
+			}
+		}
 	}
+	return nil
+}
 
-	return &blc, nil
+// Options tunes how the rules are executed.
+type Options struct {
+	// Concurrency is the number of workers applying the actions on the bucket.
+	Concurrency int
+	// TargetClient creates the client of a zone targeted by a transition. When
+	// nil, the client of the bucket is used for every zone.
+	TargetClient func(zone string) (S3Client, error)
+	// BypassGovernanceRetention removes the versions under a governance retention.
+	BypassGovernanceRetention bool
+	// Clock dates the run, time.Now when nil. The ages of the versions and the
+	// uploads are computed from it.
+	Clock Clock
+	// Inventory replaces the listing of the bucket by a recorded one, for DryRun
+	// only: a recorded listing can be stale. The client is not used then, and can
+	// be nil.
+	Inventory *Inventory
+}
+
+func newOptions(optFns []func(*Options)) Options {
+	options := Options{Concurrency: 1}
+	for _, fn := range optFns {
+		fn(&options)
+	}
+	return options
+}
 
+func Execute(client S3Client, bucket string, blc config.BucketLifecycleConfiguration, optFns ...func(*Options)) error {
+	options := newOptions(optFns)
+	if options.Inventory != nil {
+		return errors.New("an inventory can only be planned with DryRun, not executed")
+	}
+	return run(client, bucket, blc, options, newClientHandler(client, &bucket, options))
 }
 
-func Execute(client *s3.Client, bucket string, blc config.BucketLifecycleConfiguration) error {
+// DryRun walks the rules like Execute but only records the actions that would be
+// applied, leaving the bucket untouched.
+func DryRun(client S3Client, bucket string, blc config.BucketLifecycleConfiguration, optFns ...func(*Options)) (*Plan, error) {
+	plan := &Plan{}
+	if err := run(client, bucket, blc, newOptions(optFns), plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
 
+// enabledRules returns the rules of the configuration which are enabled.
+func enabledRules(blc config.BucketLifecycleConfiguration) []config.Rule {
+	rules := make([]config.Rule, 0, len(blc.Rules))
 	for _, rule := range blc.Rules {
-		err := applyRule(client, &bucket, rule)
-		if err != nil {
-			return err
+		if rule.Status != "Enabled" {
+			log.Printf("[rule] %s is %s, skipping", rule.ID, rule.Status)
+			continue
 		}
+		rules = append(rules, rule)
 	}
+	return rules
+}
 
-	return nil
+func run(client S3Client, bucket string, blc config.BucketLifecycleConfiguration, options Options, handler ActionHandler) error {
+	rules := enabledRules(blc)
+	if len(rules) == 0 {
+		return nil
+	}
+	if options.Inventory != nil {
+		return runInventory(rules, options.Inventory, options, handler)
+	}
+
+	versioning, err := client.GetBucketVersioning(context.Background(), &s3.GetBucketVersioningInput{Bucket: &bucket})
+	if err != nil {
+		return err
+	}
+
+	switch versioning.Status {
+	case types.BucketVersioningStatusSuspended:
+		log.Printf("%s has versioning suspended", bucket)
+	case "":
+		log.Printf("%s is not a versioned bucket, expired objects are removed for good", bucket)
+	}
+
+	defer handler.Flush()
+
+	if err := applyAbortIncompleteMultipartUpload(client, &bucket, rules, options.Clock.now(), handler); err != nil {
+		return err
+	}
+
+	planner := &Planner{
+		Rules:            rules,
+		Versioning:       versioning.Status,
+		FetchTags:        newTagFetcher(client, &bucket, versioning.Status),
+		BypassGovernance: options.BypassGovernanceRetention,
+		Clock:            options.Clock,
+	}
+	if objectLockEnabled(client, &bucket) {
+		planner.FetchLock = newLockFetcher(client, &bucket)
+	}
+	return applyRules(client, &bucket, planner, handler)
 }
@@ -2,6 +2,7 @@ package cmd_test
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
 	"strings"
 	"testing"
@@ -72,13 +73,22 @@ func CreateClient(cfg aws.Config) *s3.Client {
 }
 
 func WithClient(f func(client *s3.Client)) {
+	WithBucket(&s3.CreateBucketInput{Bucket: &bucket, ObjectLockEnabledForBucket: true}, f)
+}
+
+// WithUnversionedClient creates a bucket on which versioning is not enabled
+func WithUnversionedClient(f func(client *s3.Client)) {
+	WithBucket(&s3.CreateBucketInput{Bucket: &bucket}, f)
+}
+
+func WithBucket(input *s3.CreateBucketInput, f func(client *s3.Client)) {
 	cfg, err := CreateConfig()
 
 	if err != nil {
 		panic(err)
 	}
 	client = CreateClient(cfg)
-	_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &bucket, ObjectLockEnabledForBucket: true})
+	_, err = client.CreateBucket(ctx, input)
 	if err != nil {
 		panic(err)
 	}
@@ -86,6 +96,13 @@ func WithClient(f func(client *s3.Client)) {
 	f(client)
 }
 
+func PutBucketVersioning(client *s3.Client, status types.BucketVersioningStatus) {
+	_, err := client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{Bucket: &bucket, VersioningConfiguration: &types.VersioningConfiguration{Status: status}})
+	if err != nil {
+		panic(err)
+	}
+}
+
 func LoadConfig(configPath string) bconfig.BucketLifecycleConfiguration {
 	cfg, err := cmd.LoadConfig(configPath)
 	if err != nil {
@@ -102,6 +119,14 @@ func PutObject(client *s3.Client, key string) *s3.PutObjectOutput {
 	return output
 }
 
+func PutObjectWithTags(client *s3.Client, key string, tagging string) *s3.PutObjectOutput {
+	output, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: &bucket, Key: &key, Body: strings.NewReader("toto"), Tagging: &tagging})
+	if err != nil {
+		panic(err)
+	}
+	return output
+}
+
 func DeleteObject(client *s3.Client, key string) *s3.DeleteObjectOutput {
 	output, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
 	if err != nil {
@@ -166,6 +191,76 @@ func TestExpiration0DaysOneKeyMultipleVersions(t *testing.T) {
 	})
 }
 
+func TestExpiration0DaysDisabledRule(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_0_days_disabled.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 1, len(versions))
+		require.False(t, versions[0].DeleteMarker)
+	})
+}
+
+func TestDryRunExpiration0Days(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		output := PutObject(client, "key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_0_days.json")
+		plan, err := cmd.DryRun(client, bucket, cfg)
+		require.NoError(t, err)
+		require.Equal(t, []cmd.Action{{
+			Type:      cmd.ActionExpire,
+			Key:       "key1",
+			VersionId: *output.VersionId,
+			RuleID:    "ExampleRule",
+			Reason:    cmd.ReasonExpiration,
+		}}, plan.Actions)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 1, len(versions))
+		require.False(t, versions[0].DeleteMarker)
+	})
+}
+
+func TestDryRunNewerNoncurrentVersions(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+		plan, err := cmd.DryRun(client, bucket, cfg)
+		require.NoError(t, err)
+		require.Equal(t, 3, len(plan.Actions))
+		require.Equal(t, cmd.ReasonExpiration, plan.Actions[0].Reason)
+		require.Equal(t, cmd.ReasonNewerNoncurrentVersions, plan.Actions[1].Reason)
+		require.Equal(t, cmd.ReasonNewerNoncurrentVersions, plan.Actions[2].Reason)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 3, len(versions))
+	})
+}
+
+func TestOverlappingRulesSinglePass(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "key1")
+		cfg := LoadConfig("../testdata/rules_with_overlapping_expiration.json")
+		plan, err := cmd.DryRun(client, bucket, cfg)
+		require.NoError(t, err)
+		require.Equal(t, 3, len(plan.Actions))
+		require.Equal(t, "ExpireAll", plan.Actions[0].RuleID)
+		require.Equal(t, cmd.ActionExpire, plan.Actions[0].Type)
+		require.Equal(t, "ExpireDocuments", plan.Actions[1].RuleID)
+		require.Equal(t, cmd.ActionDeleteVersion, plan.Actions[1].Type)
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 4, len(versions))
+		require.True(t, versions[0].DeleteMarker)
+		require.False(t, versions[1].DeleteMarker)
+		require.True(t, versions[2].DeleteMarker)
+		require.False(t, versions[3].DeleteMarker)
+	})
+}
+
 func TestExpiration1DaysOneKeyOneVersion(t *testing.T) {
 	WithClient(func(client *s3.Client) {
 		PutObject(client, "key1")
@@ -178,7 +273,7 @@ func TestExpiration1DaysOneKeyOneVersion(t *testing.T) {
 
 func TestNewerNoncurrentVersionsOneKeyOneVersion(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -192,9 +287,9 @@ func TestNewerNoncurrentVersionsOneKeyOneVersion(t *testing.T) {
 
 func TestNewerNoncurrentVersionsOneKeyMultipleVersions(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -208,12 +303,12 @@ func TestNewerNoncurrentVersionsOneKeyMultipleVersions(t *testing.T) {
 
 func TestNewerNoncurrentVersionsMultipleKeysMultipleVersions(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key2")
-		PutObject(client, "key2")
-		PutObject(client, "key2")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key2")
+		PutObject(client, "documents/key2")
+		PutObject(client, "documents/key2")
 		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -225,13 +320,33 @@ func TestNewerNoncurrentVersionsMultipleKeysMultipleVersions(t *testing.T) {
 	})
 }
 
+func TestNewerNoncurrentVersionsMultipleKeysConcurrency(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		keys := []string{"documents/key1", "documents/key2", "documents/key3", "documents/key4"}
+		for _, key := range keys {
+			PutObject(client, key)
+			PutObject(client, key)
+			PutObject(client, key)
+		}
+		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+		_ = cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Concurrency = 3 })
+		versions := ListObjectVersions(client)
+		require.Equal(t, 2*len(keys), len(versions))
+		for i, key := range keys {
+			require.Equal(t, key, versions[2*i].Key)
+			require.True(t, versions[2*i].DeleteMarker)
+			require.False(t, versions[2*i+1].DeleteMarker)
+		}
+	})
+}
+
 func Test1NewerNoncurrentVersionsOneKeyMultipleVersions(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_1.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -243,11 +358,11 @@ func Test1NewerNoncurrentVersionsOneKeyMultipleVersions(t *testing.T) {
 
 func Test2NewerNoncurrentVersionsOneKeyMultipleVersions(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_2.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -257,9 +372,29 @@ func Test2NewerNoncurrentVersionsOneKeyMultipleVersions(t *testing.T) {
 	})
 }
 
-func TestNonCurrentDays0DaysOneKeyOneVersion(t *testing.T) {
+func TestNewerNoncurrentVersionsOutsidePrefix(t *testing.T) {
 	WithClient(func(client *s3.Client) {
 		PutObject(client, "key1")
+		PutObject(client, "key1")
+		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 5, len(versions))
+		require.Equal(t, "documents/key1", versions[0].Key)
+		require.True(t, versions[0].DeleteMarker)
+		require.Equal(t, "key1", versions[2].Key)
+		require.True(t, versions[2].IsLatest)
+		require.False(t, versions[2].DeleteMarker)
+	})
+}
+
+func TestNonCurrentDays0DaysOneKeyOneVersion(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -273,9 +408,9 @@ func TestNonCurrentDays0DaysOneKeyOneVersion(t *testing.T) {
 
 func TestNonCurrentDays0DaysOneKeyMultipleVersions(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -289,12 +424,12 @@ func TestNonCurrentDays0DaysOneKeyMultipleVersions(t *testing.T) {
 
 func TestNonCurrentDays0DaysMultipleKeysMultipleVersions(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key1")
-		PutObject(client, "key2")
-		PutObject(client, "key2")
-		PutObject(client, "key2")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		PutObject(client, "documents/key2")
+		PutObject(client, "documents/key2")
+		PutObject(client, "documents/key2")
 		cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -308,7 +443,7 @@ func TestNonCurrentDays0DaysMultipleKeysMultipleVersions(t *testing.T) {
 
 func TestNonCurrentDays1DaysOneKeyOneVersion(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
 		cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_1_days.json")
 		_ = cmd.Execute(client, bucket, cfg)
 		versions := ListObjectVersions(client)
@@ -348,7 +483,7 @@ func TestExpiredObjectDeleteMarker1Days(t *testing.T) {
 
 func TestWithoutExpirationDays(t *testing.T) {
 	WithClient(func(client *s3.Client) {
-		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
 		versions := ListObjectVersions(client)
 		require.Equal(t, 1, len(versions))
 		cfg := LoadConfig("../testdata/rule_without_expiration.json")
@@ -358,6 +493,32 @@ func TestWithoutExpirationDays(t *testing.T) {
 	})
 }
 
+func TestExpirationWithAndFilter(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "key1")
+		PutObject(client, "documents/key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_and_filter.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 3, len(versions))
+		require.Equal(t, "documents/key1", versions[0].Key)
+		require.True(t, versions[0].DeleteMarker)
+		require.Equal(t, "key1", versions[2].Key)
+		require.False(t, versions[2].DeleteMarker)
+	})
+}
+
+func TestExpirationWithSizeFilter(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_size_filter.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 1, len(versions))
+		require.False(t, versions[0].DeleteMarker)
+	})
+}
+
 func TestAbortIncompleteMultipartUpload0Days(t *testing.T) {
 	WithClient(func(client *s3.Client) {
 		CreateMultipartUpload(client, "key1")
@@ -384,6 +545,376 @@ func TestAbortIncompleteMultipartUpload1Days(t *testing.T) {
 	})
 }
 
+func TestExpiration0DaysUnversionedBucket(t *testing.T) {
+	WithUnversionedClient(func(client *s3.Client) {
+		PutObject(client, "key1")
+		PutObject(client, "key2")
+		cfg := LoadConfig("../testdata/rule_with_expiration_0_days.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 0, len(versions))
+	})
+}
+
+func TestExpiration0DaysSuspendedBucket(t *testing.T) {
+	WithUnversionedClient(func(client *s3.Client) {
+		PutBucketVersioning(client, types.BucketVersioningStatusEnabled)
+		PutObject(client, "key1")
+		PutBucketVersioning(client, types.BucketVersioningStatusSuspended)
+		PutObject(client, "key2")
+		cfg := LoadConfig("../testdata/rule_with_expiration_0_days.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		// key1 keeps its version behind a delete marker, the null version of key2
+		// is replaced by the delete marker
+		require.Equal(t, 3, len(versions))
+		require.Equal(t, "key1", versions[0].Key)
+		require.True(t, versions[0].DeleteMarker)
+		require.False(t, versions[1].DeleteMarker)
+		require.Equal(t, "key2", versions[2].Key)
+		require.True(t, versions[2].DeleteMarker)
+		require.Equal(t, cmd.NullVersionId, versions[2].VersionId)
+	})
+}
+
+func TestPlanSuspendedNullVersionExpiration(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_1.json")
+	versions := History("documents/key1", 0, 1, 2)
+	versions[0].VersionId = cmd.NullVersionId
+	// The expired null version is not kept as a noncurrent version: v2 is the
+	// newest noncurrent version
+	planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusSuspended}
+	actions := planner.PlanVersions(versions)
+	require.Equal(t, []string{cmd.NullVersionId}, PlannedVersionIds(actions, cmd.ReasonExpiration))
+	require.Equal(t, []string{"v1"}, PlannedVersionIds(actions, cmd.ReasonNewerNoncurrentVersions))
+}
+
+func TestPlanUnversioned(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_1_days.json")
+	planner := &cmd.Planner{Rules: cfg.Rules}
+	require.Equal(t, 1, len(planner.PlanVersions(History("key1", 2))))
+	require.Equal(t, 0, len(planner.PlanVersions(History("key1", 0.5))))
+}
+
+func TestExpirationDateOneKeyOneVersion(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_date.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 2, len(versions))
+		require.True(t, versions[0].IsLatest)
+		require.True(t, versions[0].DeleteMarker)
+	})
+}
+
+func TestPlanExpirationDate(t *testing.T) {
+	past := LoadConfig("../testdata/rule_with_expiration_date.json")
+	planner := &cmd.Planner{Rules: past.Rules, Versioning: types.BucketVersioningStatusEnabled}
+	require.Equal(t, []string{"v1"}, PlannedVersionIds(planner.PlanVersions(History("key1", 0)), cmd.ReasonExpiration))
+
+	future := LoadConfig("../testdata/rule_with_expiration_future_date.json")
+	planner = &cmd.Planner{Rules: future.Rules, Versioning: types.BucketVersioningStatusEnabled}
+	require.Empty(t, planner.PlanVersions(History("key1", 1000)))
+}
+
+func TestLoadConfigExpirationDateAndDays(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_rule_with_expiration_date_and_days.json")
+	require.Error(t, err)
+}
+
+func TestLoadConfigExpirationDateNotMidnight(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_rule_with_expiration_date_not_midnight.json")
+	require.ErrorContains(t, err, "midnight UTC")
+}
+
+func TestLoadBucketConfig(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		_, err := client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+			Bucket: &bucket,
+			LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{{
+				ID:         aws.String("RULE001"),
+				Status:     types.ExpirationStatusEnabled,
+				Filter:     &types.LifecycleRuleFilterMemberPrefix{Value: "documents/"},
+				Expiration: &types.LifecycleExpiration{Days: 1},
+			}}},
+		})
+		require.NoError(t, err)
+		defer func() {
+			_, _ = client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: &bucket})
+		}()
+
+		cfg, err := cmd.LoadBucketConfig(client, bucket)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(cfg.Rules))
+		require.Equal(t, "documents/", *cfg.Rules[0].Filter.Prefix)
+		require.Equal(t, 1, *cfg.Rules[0].Expiration.Days)
+	})
+}
+
+func TestExpirationWithTagFilter(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObjectWithTags(client, "key1", "lifecycle=ephemeral")
+		PutObjectWithTags(client, "key2", "lifecycle=permanent")
+		PutObject(client, "key3")
+		cfg := LoadConfig("../testdata/rule_with_expiration_tag.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 4, len(versions))
+		require.Equal(t, "key1", versions[0].Key)
+		require.True(t, versions[0].DeleteMarker)
+		require.False(t, versions[2].DeleteMarker)
+		require.False(t, versions[3].DeleteMarker)
+	})
+}
+
+func TestPlanTagFilterFetchesTagsOncePerVersion(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_tag.json")
+	fetched := map[string]int{}
+	planner := &cmd.Planner{
+		Rules:      append(cfg.Rules, cfg.Rules...),
+		Versioning: types.BucketVersioningStatusEnabled,
+		FetchTags: func(version cmd.Version) (map[string]string, error) {
+			fetched[version.VersionId]++
+			if version.VersionId == "v2" {
+				return map[string]string{"lifecycle": "ephemeral"}, nil
+			}
+			return map[string]string{}, nil
+		},
+	}
+	actions := planner.PlanVersions(History("key1", 0, 1, 2))
+	require.Equal(t, []string{"v2"}, PlannedVersionIds(actions, cmd.ReasonNoncurrentDays))
+	require.Empty(t, PlannedVersionIds(actions, cmd.ReasonExpiration))
+	require.Equal(t, map[string]int{"v3": 1, "v2": 1, "v1": 1}, fetched)
+}
+
+func TestPlanAndTagsFilter(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_and_tags.json")
+	planner := &cmd.Planner{
+		Rules:      cfg.Rules,
+		Versioning: types.BucketVersioningStatusEnabled,
+		FetchTags: func(version cmd.Version) (map[string]string, error) {
+			return map[string]string{"lifecycle": "ephemeral", "team": "ci"}, nil
+		},
+	}
+	require.Equal(t, 1, len(planner.PlanVersions(History("documents/key1", 0))))
+	require.Empty(t, planner.PlanVersions(History("key1", 0)))
+	planner.FetchTags = nil
+	require.Empty(t, planner.PlanVersions(History("documents/key1", 0)))
+}
+
+func TestTransitionDeleteSource(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		archive := "archive"
+		_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &archive})
+		require.NoError(t, err)
+		defer func() {
+			_, _ = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &archive, Key: aws.String("key1")})
+			_, _ = client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &archive})
+		}()
+
+		_, err = client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      &bucket,
+			Key:         aws.String("key1"),
+			Body:        strings.NewReader("toto"),
+			ContentType: aws.String("text/plain"),
+			Metadata:    map[string]string{"owner": "ci"},
+			Tagging:     aws.String("lifecycle=ephemeral"),
+		})
+		require.NoError(t, err)
+		cfg := LoadConfig("../testdata/rule_with_transition.json")
+		_ = cmd.Execute(client, bucket, cfg)
+
+		versions := ListObjectVersions(client)
+		require.Equal(t, 2, len(versions))
+		require.True(t, versions[0].DeleteMarker)
+
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &archive, Key: aws.String("key1")})
+		require.NoError(t, err)
+		require.Equal(t, int64(4), head.ContentLength)
+		require.Equal(t, "text/plain", aws.ToString(head.ContentType))
+		require.Equal(t, "ci", head.Metadata["owner"])
+		tagging, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: &archive, Key: aws.String("key1")})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(tagging.TagSet))
+		require.Equal(t, "ephemeral", aws.ToString(tagging.TagSet[0].Value))
+	})
+}
+
+func TestTransitionMissingTargetKeepsSource(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		PutObject(client, "key1")
+		cfg := LoadConfig("../testdata/rule_with_transition.json")
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 1, len(versions))
+		require.False(t, versions[0].DeleteMarker)
+	})
+}
+
+func TestPlanTransition(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_transition.json")
+	planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}
+	actions := planner.PlanVersions(History("key1", 0, 1))
+	require.Equal(t, []cmd.Action{
+		{Type: cmd.ActionTransition, Key: "key1", VersionId: "v2", RuleID: "ExampleRule", Reason: cmd.ReasonTransition, TargetBucket: "archive", TargetKey: "key1"},
+		{Type: cmd.ActionExpire, Key: "key1", VersionId: "v2", RuleID: "ExampleRule", Reason: cmd.ReasonTransition},
+	}, actions)
+}
+
+func TestPlanTransitionBeforeExpiration(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_transition_and_expiration.json")
+	planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}
+	actions := planner.PlanVersions(History("key1", 0))
+	require.Equal(t, 2, len(actions))
+	require.Equal(t, cmd.ActionTransition, actions[0].Type)
+	require.Equal(t, "de-fra-1", actions[0].TargetZone)
+	require.Equal(t, cmd.ActionExpire, actions[1].Type)
+	require.Equal(t, cmd.ReasonExpiration, actions[1].Reason)
+}
+
+func TestNoncurrentVersionTransition(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		archive := "archive"
+		_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: &archive})
+		require.NoError(t, err)
+		first := PutObject(client, "key1")
+		PutObject(client, "key1")
+		archived := cmd.NoncurrentTargetKey("key1", *first.VersionId)
+		defer func() {
+			_, _ = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &archive, Key: &archived})
+			_, _ = client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &archive})
+		}()
+
+		cfg := LoadConfig("../testdata/rule_with_noncurrent_version_transition.json")
+		_ = cmd.Execute(client, bucket, cfg)
+
+		versions := ListObjectVersions(client)
+		require.Equal(t, 1, len(versions))
+		require.True(t, versions[0].IsLatest)
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &archive, Key: &archived})
+		require.NoError(t, err)
+		require.Equal(t, int64(4), head.ContentLength)
+	})
+}
+
+func TestPlanNoncurrentVersionTransition(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_noncurrent_version_transition.json")
+	planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}
+	versions := History("key1", 0, 1, 2)
+	versions[1].DeleteMarker = true
+	actions := planner.PlanVersions(versions)
+	require.Equal(t, []cmd.Action{
+		{Type: cmd.ActionTransition, Key: "key1", VersionId: "v1", RuleID: "ExampleRule", Reason: cmd.ReasonNoncurrentTransition, TargetBucket: "archive", TargetKey: "key1/v1"},
+		{Type: cmd.ActionDeleteVersion, Key: "key1", VersionId: "v1", RuleID: "ExampleRule", Reason: cmd.ReasonNoncurrentTransition},
+	}, actions)
+}
+
+func TestPlanNoncurrentVersionExpirationOverTransition(t *testing.T) {
+	cfg := LoadConfig("../testdata/rules_with_noncurrent_version_transition_and_expiration.json")
+	planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}
+	// v3 is kept, v2 is archived and v1 is removed for good
+	actions := planner.PlanVersions(History("key1", 10, 100, 400, 500))
+	require.Equal(t, 3, len(actions))
+	require.Equal(t, cmd.ActionTransition, actions[0].Type)
+	require.Equal(t, "v2", actions[0].VersionId)
+	require.Equal(t, cmd.ReasonNoncurrentTransition, actions[1].Reason)
+	require.Equal(t, "v2", actions[1].VersionId)
+	require.Equal(t, cmd.ReasonNoncurrentDays, actions[2].Reason)
+	require.Equal(t, "v1", actions[2].VersionId)
+}
+
+func TestNonCurrentDaysLegalHold(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		first := PutObject(client, "documents/key1")
+		PutObject(client, "documents/key1")
+		_, err := client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+			Bucket:    &bucket,
+			Key:       aws.String("documents/key1"),
+			VersionId: first.VersionId,
+			LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOn},
+		})
+		require.NoError(t, err)
+		defer func() {
+			_, _ = client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{
+				Bucket:    &bucket,
+				Key:       aws.String("documents/key1"),
+				VersionId: first.VersionId,
+				LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOff},
+			})
+		}()
+
+		cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
+		plan, err := cmd.DryRun(client, bucket, cfg)
+		require.NoError(t, err)
+		require.Equal(t, cmd.ActionSkip, plan.Actions[1].Type)
+		require.Equal(t, cmd.ReasonLegalHold, plan.Actions[1].Reason)
+
+		_ = cmd.Execute(client, bucket, cfg)
+		versions := ListObjectVersions(client)
+		require.Equal(t, 3, len(versions))
+	})
+}
+
+func TestNonCurrentDaysGovernanceRetentionBypass(t *testing.T) {
+	WithClient(func(client *s3.Client) {
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:                    &bucket,
+			Key:                       aws.String("documents/key1"),
+			Body:                      strings.NewReader("toto"),
+			ObjectLockMode:            types.ObjectLockModeGovernance,
+			ObjectLockRetainUntilDate: aws.Time(time.Now().Add(24 * time.Hour)),
+		})
+		require.NoError(t, err)
+		PutObject(client, "documents/key1")
+		cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
+
+		plan, err := cmd.DryRun(client, bucket, cfg)
+		require.NoError(t, err)
+		require.Equal(t, cmd.ReasonRetention, plan.Actions[1].Reason)
+
+		_ = cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.BypassGovernanceRetention = true })
+		versions := ListObjectVersions(client)
+		require.Equal(t, 2, len(versions))
+	})
+}
+
+func TestPlanObjectLock(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
+	retainUntil := time.Now().Add(24 * time.Hour)
+	locks := map[string]cmd.ObjectLock{
+		"v4": {LegalHold: true},
+		"v3": {Mode: types.ObjectLockRetentionModeCompliance, RetainUntil: &retainUntil},
+		"v2": {Mode: types.ObjectLockRetentionModeGovernance, RetainUntil: &retainUntil},
+	}
+	planner := &cmd.Planner{
+		Rules:      cfg.Rules,
+		Versioning: types.BucketVersioningStatusEnabled,
+		FetchLock: func(version cmd.Version) (cmd.ObjectLock, error) {
+			if version.VersionId == "v1" {
+				return cmd.ObjectLock{}, fmt.Errorf("access denied")
+			}
+			return locks[version.VersionId], nil
+		},
+	}
+	versions := History("documents/key1", 0, 1, 2, 3, 4)
+	actions := planner.PlanVersions(versions)
+	require.Equal(t, 5, len(actions))
+	require.Equal(t, cmd.ActionExpire, actions[0].Type)
+	require.Equal(t, cmd.ReasonLegalHold, actions[1].Reason)
+	require.Equal(t, cmd.ReasonRetention, actions[2].Reason)
+	require.Equal(t, cmd.ReasonRetention, actions[3].Reason)
+	require.Equal(t, cmd.ReasonLockUnknown, actions[4].Reason)
+	for _, action := range actions[1:] {
+		require.Equal(t, cmd.ActionSkip, action.Type)
+	}
+
+	planner.BypassGovernance = true
+	actions = planner.PlanVersions(versions)
+	require.Equal(t, cmd.ActionDeleteVersion, actions[3].Type)
+	require.Equal(t, cmd.ReasonNoncurrentDays, actions[3].Reason)
+}
+
 func TestSortVersionsByDate(t *testing.T) {
 	version1 := cmd.Version{
 		IsLatest:     true,
@@ -474,3 +1005,78 @@ func TestAgeInDaysWithYesterdayTwoDaysAgo(t *testing.T) {
 	now := time.Now()
 	require.Equal(t, 20, cmd.AgeInDays(now, now.Add(time.Duration(-1*time.Hour*20*24))))
 }
+
+func TestNoncurrentSinceSuccessorLastModified(t *testing.T) {
+	now := time.Now()
+	versions := []cmd.Version{
+		{Key: "key1", IsLatest: true, LastModified: now.Add(-24 * time.Hour)},
+		{Key: "key1", LastModified: now.Add(-365 * 24 * time.Hour)},
+		{Key: "key1", LastModified: now.Add(-400 * 24 * time.Hour)},
+	}
+	require.Equal(t, versions[0].LastModified, cmd.NoncurrentSince(versions, 1))
+	require.Equal(t, versions[1].LastModified, cmd.NoncurrentSince(versions, 2))
+	require.Equal(t, 1, cmd.AgeInDays(now, cmd.NoncurrentSince(versions, 1)))
+}
+
+// History returns the versions of a key, newest first, created the given number
+// of days ago. The first one is the latest.
+func History(key string, days ...float64) []cmd.Version {
+	now := time.Now()
+	versions := []cmd.Version{}
+	for i, d := range days {
+		versions = append(versions, cmd.Version{
+			Key:          key,
+			IsLatest:     i == 0,
+			LastModified: now.Add(-time.Duration(d * 24 * float64(time.Hour))),
+			VersionId:    fmt.Sprintf("v%d", len(days)-i),
+		})
+	}
+	return versions
+}
+
+func PlannedVersionIds(actions []cmd.Action, reason cmd.Reason) []string {
+	ids := []string{}
+	for _, action := range actions {
+		if action.Reason == reason {
+			ids = append(ids, action.VersionId)
+		}
+	}
+	return ids
+}
+
+func TestPlanNewerNoncurrentVersions1(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_1.json")
+	actions := (&cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}).PlanVersions(History("documents/key1", 0, 1, 2, 3))
+	require.Equal(t, []string{"v4"}, PlannedVersionIds(actions, cmd.ReasonExpiration))
+	require.Equal(t, []string{"v3", "v2", "v1"}, PlannedVersionIds(actions, cmd.ReasonNewerNoncurrentVersions))
+}
+
+func TestPlanNewerNoncurrentVersionsOutsidePrefix(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+	require.Empty(t, (&cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}).PlanVersions(History("key1", 0, 1, 2, 3)))
+}
+
+func TestPlanNonCurrentDays1Days(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_1_days.json")
+	// v2 is old but was replaced half a day ago
+	actions := (&cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}).PlanVersions(History("documents/key1", 0.5, 400, 401))
+	require.Equal(t, []string{"v3"}, PlannedVersionIds(actions, cmd.ReasonExpiration))
+	require.Equal(t, []string{"v1"}, PlannedVersionIds(actions, cmd.ReasonNoncurrentDays))
+}
+
+func TestPlanNoncurrentDaysAndNewerNoncurrentVersions(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_noncurrent_days_and_newer_noncurrent_versions.json")
+	// v5 and v4 are the 2 newest noncurrent versions, v3 has been noncurrent for
+	// 20 days only: v2 and v1 are removed
+	actions := (&cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}).PlanVersions(History("documents/key1", 0, 10, 20, 40, 50, 60))
+	require.Equal(t, []string{"v2", "v1"}, PlannedVersionIds(actions, cmd.ReasonNoncurrentDays))
+	require.Equal(t, 2, len(actions))
+}
+
+func TestPlanNoncurrentDaysAndNewerNoncurrentVersionsKeepsNewest(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_noncurrent_days_and_newer_noncurrent_versions.json")
+	// Every noncurrent version is older than 30 days, but the 2 newest are kept
+	actions := (&cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled}).PlanVersions(History("documents/key1", 100, 200, 300, 400))
+	require.Equal(t, []string{"v1"}, PlannedVersionIds(actions, cmd.ReasonNoncurrentDays))
+	require.Equal(t, 1, len(actions))
+}
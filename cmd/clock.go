@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// Clock returns the current date, from which the ages of the versions and the
+// uploads are computed.
+type Clock func() time.Time
+
+// FixedClock always returns the given date, to find out what the rules do on
+// that day.
+func FixedClock(now time.Time) Clock {
+	return func() time.Time { return now }
+}
+
+func (c Clock) now() time.Time {
+	if c == nil {
+		return time.Now()
+	}
+	return c()
+}
+
+// ParseDate reads a date like Expiration.Date: as 2006-01-02 (midnight UTC) or in
+// RFC 3339.
+func ParseDate(value string) (time.Time, error) {
+	var date config.Date
+	if err := date.UnmarshalText([]byte(value)); err != nil {
+		return time.Time{}, err
+	}
+	return date.Time, nil
+}
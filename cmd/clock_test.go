@@ -0,0 +1,98 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+)
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+func plannedTypes(actions []cmd.Action) []cmd.ActionType {
+	types := []cmd.ActionType{}
+	for _, action := range actions {
+		types = append(types, action.Type)
+	}
+	return types
+}
+
+func TestPlanClock(t *testing.T) {
+	cfg := LoadConfig("../testdata/rules_with_30_90_365_days.json")
+	versions := []cmd.Version{{Key: "key1", VersionId: "v1", IsLatest: true, LastModified: t0}}
+	plan := func(now time.Time) []cmd.ActionType {
+		planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled, Clock: cmd.FixedClock(now)}
+		return plannedTypes(planner.PlanVersions(versions))
+	}
+
+	require.Empty(t, plan(t0.Add(days(29))))
+	require.Equal(t, []cmd.ActionType{cmd.ActionTransition}, plan(t0.Add(days(30))))
+	require.Equal(t, []cmd.ActionType{cmd.ActionTransition, cmd.ActionExpire}, plan(t0.Add(days(90))))
+}
+
+func TestPlanClockNoncurrentDays(t *testing.T) {
+	cfg := LoadConfig("../testdata/rules_with_30_90_365_days.json")
+	versions := []cmd.Version{
+		{Key: "key1", VersionId: "v2", IsLatest: true, LastModified: t0.Add(days(10)), DeleteMarker: true},
+		{Key: "key1", VersionId: "v1", LastModified: t0},
+	}
+	plan := func(now time.Time) []cmd.Action {
+		planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled, Clock: cmd.FixedClock(now)}
+		return planner.PlanVersions(versions)
+	}
+
+	// v1 has been noncurrent since the delete marker was created
+	require.Empty(t, plan(t0.Add(days(374))))
+	require.Equal(t, []string{"v1"}, PlannedVersionIds(plan(t0.Add(days(375))), cmd.ReasonNoncurrentDays))
+}
+
+func TestPlanClockExpirationDate(t *testing.T) {
+	cfg := LoadConfig("../testdata/rule_with_expiration_future_date.json")
+	date := cfg.Rules[0].Expiration.Date.Time
+	versions := []cmd.Version{{Key: "key1", VersionId: "v1", IsLatest: true, LastModified: t0}}
+
+	planner := &cmd.Planner{Rules: cfg.Rules, Versioning: types.BucketVersioningStatusEnabled, Clock: cmd.FixedClock(date.Add(-time.Second))}
+	require.Empty(t, planner.PlanVersions(versions))
+	planner.Clock = cmd.FixedClock(date)
+	require.Equal(t, []cmd.ActionType{cmd.ActionExpire}, plannedTypes(planner.PlanVersions(versions)))
+}
+
+func TestMemClock(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	client.Now = func() time.Time { return t0 }
+	memPut(t, client, "key1")
+	_, err := client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{Bucket: &bucket, Key: aws.String("key2")})
+	require.NoError(t, err)
+
+	cfg := LoadConfig("../testdata/rule_with_expiration_1_days.json")
+	require.NoError(t, cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Clock = cmd.FixedClock(t0.Add(12 * time.Hour)) }))
+	require.Equal(t, 1, len(memVersions(t, client)))
+	require.NoError(t, cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Clock = cmd.FixedClock(t0.Add(days(1))) }))
+	require.Equal(t, 2, len(memVersions(t, client)))
+
+	cfg = LoadConfig("../testdata/rule_with_abort_incomplete_multipart_upload_1_days.json")
+	plan, err := cmd.DryRun(client, bucket, cfg, func(o *cmd.Options) { o.Clock = cmd.FixedClock(t0.Add(days(7))) })
+	require.NoError(t, err)
+	require.Equal(t, []cmd.ActionType{cmd.ActionAbortMultipartUpload}, plannedTypes(plan.Actions))
+}
+
+func TestParseDate(t *testing.T) {
+	date, err := cmd.ParseDate("2024-03-01")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), date)
+
+	date, err = cmd.ParseDate("2024-03-01T12:00:00+01:00")
+	require.NoError(t, err)
+	require.True(t, date.Equal(time.Date(2024, time.March, 1, 11, 0, 0, 0, time.UTC)))
+
+	_, err = cmd.ParseDate("yesterday")
+	require.EqualError(t, err, `"yesterday" is neither 2006-01-02 nor RFC 3339`)
+}
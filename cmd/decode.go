@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// DecodeError locates an error of a JSON configuration.
+type DecodeError struct {
+	Line   int
+	Column int
+	// Path is the JSON path of the faulty value, such as Rules[0].Expiration.
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d (%s): %v", e.Line, e.Column, e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeJSONStrict decodes the JSON document into v, rejecting the fields v does
+// not have. Unlike encoding/json, the field names are case-sensitive, like in the
+// schema of the configuration.
+func decodeJSONStrict(data []byte, v any) error {
+	checker := &jsonChecker{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	checker.dec.UseNumber()
+	if err := checker.value(reflect.TypeOf(v), ""); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return newDecodeError(data, syntaxErr.Offset-1, "", err)
+		case errors.As(err, &typeErr):
+			return newDecodeError(data, typeErr.Offset, fieldPath(typeErr.Field),
+				fmt.Errorf("cannot use a JSON %s as %s", typeErr.Value, typeErr.Type))
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return newDecodeError(data, dec.InputOffset(), "", errors.New("unexpected data after the configuration"))
+	}
+	return nil
+}
+
+func newDecodeError(data []byte, offset int64, path string, err error) *DecodeError {
+	offset = max(0, min(offset, int64(len(data))))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return &DecodeError{Line: line, Column: column, Path: path, Err: err}
+}
+
+// jsonChecker walks a JSON document along the Go type it is decoded into, to
+// report the unknown fields with their location.
+type jsonChecker struct {
+	data []byte
+	dec  *json.Decoder
+	// lastOffset is the offset of the beginning of the last token read.
+	lastOffset int64
+}
+
+func (c *jsonChecker) token() (json.Token, error) {
+	c.lastOffset = c.dec.InputOffset()
+	for c.lastOffset < int64(len(c.data)) && strings.ContainsRune(" \t\r\n,:", rune(c.data[c.lastOffset])) {
+		c.lastOffset++
+	}
+	token, err := c.dec.Token()
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return nil, newDecodeError(c.data, syntaxErr.Offset-1, "", err)
+	}
+	if err == io.EOF {
+		return nil, newDecodeError(c.data, c.dec.InputOffset(), "", io.ErrUnexpectedEOF)
+	}
+	return token, err
+}
+
+func (c *jsonChecker) value(t reflect.Type, path string) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		if t.Kind() != reflect.Struct || config.IsDate(t) {
+			return c.typeError("object", t, path)
+		}
+		fields := jsonFields(t)
+		for c.dec.More() {
+			token, err := c.token()
+			if err != nil {
+				return err
+			}
+			name := token.(string)
+			field, ok := fields[name]
+			if !ok {
+				err := fmt.Errorf("unknown field %q", name)
+				if suggestion := suggestField(name, fields); suggestion != "" {
+					err = fmt.Errorf("unknown field %q, did you mean %q?", name, suggestion)
+				}
+				return newDecodeError(c.data, c.lastOffset, path, err)
+			}
+			if err := c.value(field, joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+		_, err = c.token()
+		return err
+	case json.Delim('['):
+		if t.Kind() != reflect.Slice {
+			return c.typeError("array", t, path)
+		}
+		for i := 0; c.dec.More(); i++ {
+			if err := c.value(t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = c.token()
+		return err
+	case nil:
+		return nil
+	}
+
+	switch token := token.(type) {
+	case string:
+		if t.Kind() != reflect.String && !config.IsDate(t) {
+			return c.typeError("string", t, path)
+		}
+	case json.Number:
+		var err error
+		switch {
+		case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+			_, err = strconv.ParseInt(token.String(), 10, t.Bits())
+		case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+			_, err = strconv.ParseUint(token.String(), 10, t.Bits())
+		case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		default:
+			return c.typeError("number", t, path)
+		}
+		// Report the fractional or out of range numbers with the path of the checker
+		if err != nil {
+			return c.typeError("number "+token.String(), t, path)
+		}
+	case bool:
+		if t.Kind() != reflect.Bool {
+			return c.typeError("boolean", t, path)
+		}
+	}
+	return nil
+}
+
+// typeError reports a value which does not have the expected type, the value
+// having just been read.
+func (c *jsonChecker) typeError(value string, t reflect.Type, path string) error {
+	return newDecodeError(c.data, c.lastOffset, path, fmt.Errorf("cannot use a JSON %s as %s", value, t))
+}
+
+// fieldPath converts the path of an encoding/json error, such as
+// Rules.0.Expiration, to the one of DecodeError.
+func fieldPath(field string) string {
+	path := ""
+	for _, name := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(name); err == nil && path != "" {
+			path += "[" + name + "]"
+		} else {
+			path = joinPath(path, name)
+		}
+	}
+	return path
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonFields returns the type of the fields of a struct by their JSON name.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for _, field := range config.Fields(t) {
+		fields[field.JSONName] = field.Type
+	}
+	return fields
+}
+
+// suggestField returns the field whose name is the closest to the unknown one,
+// if it is close enough to be a typo.
+func suggestField(name string, fields map[string]reflect.Type) string {
+	suggestion, best := "", len(name)/3+1
+	for field := range fields {
+		distance := levenshtein(strings.ToLower(name), strings.ToLower(field))
+		if distance < best || (distance == best && suggestion != "" && field < suggestion) {
+			suggestion, best = field, distance
+		}
+	}
+	return suggestion
+}
+
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
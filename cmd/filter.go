@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// MatchPrefix reports whether the key starts with every prefix set on the filter.
+// A nil filter matches every key.
+func MatchPrefix(filter *config.Filter, key string) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.Prefix != nil && !strings.HasPrefix(key, *filter.Prefix) {
+		return false
+	}
+	if filter.And != nil && filter.And.Prefix != nil && !strings.HasPrefix(key, *filter.And.Prefix) {
+		return false
+	}
+	return true
+}
+
+// MatchSize reports whether the size is strictly within the bounds set on the filter.
+func MatchSize(filter *config.Filter, size int64) bool {
+	if filter == nil {
+		return true
+	}
+	if !matchSizeBounds(filter.ObjectSizeGreaterThan, filter.ObjectSizeLessThan, size) {
+		return false
+	}
+	if filter.And != nil && !matchSizeBounds(filter.And.ObjectSizeGreaterThan, filter.And.ObjectSizeLessThan, size) {
+		return false
+	}
+	return true
+}
+
+func matchSizeBounds(greaterThan, lessThan *int64, size int64) bool {
+	if greaterThan != nil && size <= *greaterThan {
+		return false
+	}
+	if lessThan != nil && size >= *lessThan {
+		return false
+	}
+	return true
+}
+
+// MatchFilter reports whether a version is targeted by the filter, tags aside
+// (see MatchTags). Like on S3, the conditions of the And block are combined with
+// the top-level ones, and delete markers, which have no size, are only matched on
+// their prefix.
+func MatchFilter(filter *config.Filter, version Version) bool {
+	if !MatchPrefix(filter, version.Key) {
+		return false
+	}
+	if version.DeleteMarker {
+		return true
+	}
+	return MatchSize(filter, version.Size)
+}
+
+// HasTagFilter reports whether the filter targets objects by their tags.
+func HasTagFilter(filter *config.Filter) bool {
+	return filter != nil && (filter.Tag != nil || (filter.And != nil && len(filter.And.Tags) > 0))
+}
+
+// MatchTags reports whether the tags of a version include every tag of the filter.
+func MatchTags(filter *config.Filter, tags map[string]string) bool {
+	if filter == nil {
+		return true
+	}
+	expected := []config.Tag{}
+	if filter.Tag != nil {
+		expected = append(expected, *filter.Tag)
+	}
+	if filter.And != nil {
+		expected = append(expected, filter.And.Tags...)
+	}
+	for _, tag := range expected {
+		if value, ok := tags[tag.Key]; !ok || value != tag.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// newTagFetcher gets the tags of the versions with GetObjectTagging.
+func newTagFetcher(client S3Client, bucket *string, versioning types.BucketVersioningStatus) TagFetcher {
+	return func(version Version) (map[string]string, error) {
+		input := &s3.GetObjectTaggingInput{Bucket: bucket, Key: aws.String(version.Key)}
+		// Objects of a bucket which has never been versioned have no version ID
+		if versioning != "" {
+			input.VersionId = aws.String(version.VersionId)
+		}
+		output, err := client.GetObjectTagging(context.TODO(), input)
+		if err != nil {
+			return nil, err
+		}
+		tags := make(map[string]string, len(output.TagSet))
+		for _, tag := range output.TagSet {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		return tags, nil
+	}
+}
@@ -0,0 +1,68 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+	bconfig "github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+func TestMatchFilterWithoutFilter(t *testing.T) {
+	require.True(t, cmd.MatchFilter(nil, cmd.Version{Key: "key1", Size: 4}))
+}
+
+func TestMatchFilterPrefix(t *testing.T) {
+	filter := &bconfig.Filter{Prefix: aws.String("documents/")}
+	require.True(t, cmd.MatchFilter(filter, cmd.Version{Key: "documents/key1"}))
+	require.False(t, cmd.MatchFilter(filter, cmd.Version{Key: "key1"}))
+}
+
+func TestMatchFilterSize(t *testing.T) {
+	filter := &bconfig.Filter{ObjectSizeGreaterThan: aws.Int64(4), ObjectSizeLessThan: aws.Int64(10)}
+	require.False(t, cmd.MatchFilter(filter, cmd.Version{Key: "key1", Size: 4}))
+	require.True(t, cmd.MatchFilter(filter, cmd.Version{Key: "key1", Size: 5}))
+	require.False(t, cmd.MatchFilter(filter, cmd.Version{Key: "key1", Size: 10}))
+}
+
+func TestMatchFilterAnd(t *testing.T) {
+	filter := &bconfig.Filter{And: &bconfig.AndFilter{
+		Prefix:                aws.String("documents/"),
+		ObjectSizeGreaterThan: aws.Int64(2),
+		ObjectSizeLessThan:    aws.Int64(10),
+	}}
+	require.True(t, cmd.MatchFilter(filter, cmd.Version{Key: "documents/key1", Size: 4}))
+	require.False(t, cmd.MatchFilter(filter, cmd.Version{Key: "documents/key1", Size: 20}))
+	require.False(t, cmd.MatchFilter(filter, cmd.Version{Key: "key1", Size: 4}))
+}
+
+func TestMatchFilterDeleteMarkerIgnoresSize(t *testing.T) {
+	filter := &bconfig.Filter{Prefix: aws.String("documents/"), ObjectSizeGreaterThan: aws.Int64(2)}
+	require.True(t, cmd.MatchFilter(filter, cmd.Version{Key: "documents/key1", DeleteMarker: true}))
+	require.False(t, cmd.MatchFilter(filter, cmd.Version{Key: "key1", DeleteMarker: true}))
+}
+
+func TestMatchTags(t *testing.T) {
+	filter := &bconfig.Filter{Tag: &bconfig.Tag{Key: "lifecycle", Value: "ephemeral"}}
+	require.True(t, cmd.HasTagFilter(filter))
+	require.True(t, cmd.MatchTags(filter, map[string]string{"lifecycle": "ephemeral", "team": "ci"}))
+	require.False(t, cmd.MatchTags(filter, map[string]string{"lifecycle": "permanent"}))
+	require.False(t, cmd.MatchTags(filter, map[string]string{}))
+}
+
+func TestMatchTagsAnd(t *testing.T) {
+	filter := &bconfig.Filter{And: &bconfig.AndFilter{Tags: []bconfig.Tag{
+		{Key: "lifecycle", Value: "ephemeral"},
+		{Key: "team", Value: "ci"},
+	}}}
+	require.True(t, cmd.HasTagFilter(filter))
+	require.True(t, cmd.MatchTags(filter, map[string]string{"lifecycle": "ephemeral", "team": "ci"}))
+	require.False(t, cmd.MatchTags(filter, map[string]string{"lifecycle": "ephemeral"}))
+}
+
+func TestHasTagFilterWithoutTags(t *testing.T) {
+	require.False(t, cmd.HasTagFilter(nil))
+	require.False(t, cmd.HasTagFilter(&bconfig.Filter{Prefix: aws.String("documents/")}))
+}
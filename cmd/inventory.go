@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// Inventory is a recorded listing of the versions of a bucket, to plan the rules
+// offline.
+type Inventory struct {
+	Versions []Version
+	// Versioning is the versioning status of the bucket: enabled when the
+	// inventory holds delete markers or versions other than the null version.
+	Versioning types.BucketVersioningStatus
+}
+
+// inventoryColumns are the fields of an inventory record, the CSV header names
+// and the JSON keys alike.
+var inventoryColumns = []string{"Key", "VersionId", "IsLatest", "LastModified", "Size", "DeleteMarker"}
+
+// inventoryRecord is a version of an inventory, the fields are pointers to find
+// out the missing ones.
+type inventoryRecord struct {
+	Key          *string
+	VersionId    string
+	IsLatest     *bool
+	LastModified *time.Time
+	Size         int64
+	DeleteMarker bool
+}
+
+func (r inventoryRecord) version() (Version, error) {
+	switch {
+	case r.Key == nil:
+		return Version{}, errors.New("Key is required")
+	case r.IsLatest == nil:
+		return Version{}, errors.New("IsLatest is required")
+	case r.LastModified == nil:
+		return Version{}, errors.New("LastModified is required")
+	}
+	// The objects written while versioning was not enabled have no version ID
+	versionId := r.VersionId
+	if versionId == "" {
+		versionId = NullVersionId
+	}
+	return Version{
+		Key:          *r.Key,
+		IsLatest:     *r.IsLatest,
+		LastModified: *r.LastModified,
+		VersionId:    versionId,
+		DeleteMarker: r.DeleteMarker,
+		Size:         r.Size,
+	}, nil
+}
+
+// ReadInventory reads a recorded listing of a bucket according to the file
+// extension: the JSON output of aws s3api list-object-versions or
+// list-objects-v2 (.json), a CSV file (.csv) or a JSON object per line (.jsonl).
+func ReadInventory(path string) (*Inventory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var inventory *Inventory
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		inventory, err = ReadListing(file)
+	case ".csv":
+		var versions []Version
+		if versions, err = ReadInventoryCSV(file); err == nil {
+			inventory = NewInventory(versions)
+		}
+	default:
+		var versions []Version
+		if versions, err = ReadInventoryJSONL(file); err == nil {
+			inventory = NewInventory(versions)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return inventory, nil
+}
+
+// listing is the output of aws s3api list-object-versions, or of
+// list-objects-v2 whose objects are in Contents.
+type listing struct {
+	Versions      []inventoryRecord
+	DeleteMarkers []inventoryRecord
+	Contents      []inventoryRecord
+}
+
+// ReadListing decodes the JSON output of aws s3api list-object-versions, for a
+// versioned bucket, or of aws s3api list-objects-v2 otherwise.
+func ReadListing(r io.Reader) (*Inventory, error) {
+	var output listing
+	if err := json.NewDecoder(r).Decode(&output); err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, list := range []struct {
+		name    string
+		records []inventoryRecord
+	}{{"Versions", output.Versions}, {"DeleteMarkers", output.DeleteMarkers}, {"Contents", output.Contents}} {
+		for i, record := range list.records {
+			switch list.name {
+			case "DeleteMarkers":
+				record.DeleteMarker = true
+			case "Contents":
+				// The objects of an unversioned bucket are their only version
+				isLatest := true
+				record.IsLatest = &isLatest
+			}
+			version, err := record.version()
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", list.name, i, err)
+			}
+			versions = append(versions, version)
+		}
+	}
+	// The versions of an unversioned bucket are listed with the null version ID
+	return NewInventory(versions), nil
+}
+
+// NewInventory guesses the versioning status of the bucket from its versions.
+func NewInventory(versions []Version) *Inventory {
+	inventory := &Inventory{Versions: versions}
+	for _, version := range versions {
+		if version.DeleteMarker || version.VersionId != NullVersionId {
+			inventory.Versioning = types.BucketVersioningStatusEnabled
+			break
+		}
+	}
+	return inventory
+}
+
+// ReadInventoryJSONL reads an inventory holding a JSON object per line, such as
+// {"Key": "a.log", "VersionId": "v1", "IsLatest": true,
+// "LastModified": "2024-01-01T00:00:00Z", "Size": 100, "DeleteMarker": false}.
+// VersionId, Size and DeleteMarker can be left out.
+func ReadInventoryJSONL(r io.Reader) ([]Version, error) {
+	var versions []Version
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var record inventoryRecord
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		version, err := record.version()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		versions = append(versions, version)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// ReadInventoryCSV reads an inventory whose first row names the columns: Key,
+// VersionId, IsLatest, LastModified, Size and DeleteMarker, in any order. The
+// VersionId, Size and DeleteMarker columns are optional.
+func ReadInventoryCSV(r io.Reader) ([]Version, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("line 1: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		idx := slices.IndexFunc(inventoryColumns, func(column string) bool { return strings.EqualFold(column, strings.TrimSpace(name)) })
+		if idx < 0 {
+			return nil, fmt.Errorf("line 1: unknown column %s", name)
+		}
+		columns[inventoryColumns[idx]] = i
+	}
+
+	var versions []Version
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		record, err := parseInventoryRow(row, columns)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		version, err := record.version()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		versions = append(versions, version)
+	}
+}
+
+func parseInventoryRow(row []string, columns map[string]int) (inventoryRecord, error) {
+	var record inventoryRecord
+	for column, i := range columns {
+		value := row[i]
+		var err error
+		switch column {
+		case "Key":
+			record.Key = &value
+		case "VersionId":
+			record.VersionId = value
+		case "IsLatest":
+			var isLatest bool
+			isLatest, err = strconv.ParseBool(value)
+			record.IsLatest = &isLatest
+		case "LastModified":
+			var lastModified time.Time
+			lastModified, err = time.Parse(time.RFC3339, value)
+			record.LastModified = &lastModified
+		case "Size":
+			if value != "" {
+				record.Size, err = strconv.ParseInt(value, 10, 64)
+			}
+		case "DeleteMarker":
+			if value != "" {
+				record.DeleteMarker, err = strconv.ParseBool(value)
+			}
+		}
+		if err != nil {
+			return record, fmt.Errorf("%s: %w", column, err)
+		}
+	}
+	return record, nil
+}
+
+// runInventory plans the rules on the versions of an inventory rather than on the
+// listing of the bucket. The inventory holds neither the incomplete multipart
+// uploads, nor the tags, nor the Object Lock protections: they are left out.
+func runInventory(rules []config.Rule, inventory *Inventory, options Options, handler ActionHandler) error {
+	if inventory.Versioning == "" {
+		log.Printf("the inventory holds no version, expired objects are removed for good")
+	}
+	for _, rule := range rules {
+		if rule.AbortIncompleteMultipartUpload != nil {
+			log.Printf("[rule] %s aborts incomplete multipart uploads, which are not in the inventory", rule.ID)
+		}
+		if HasTagFilter(rule.Filter) {
+			log.Printf("[rule] %s filters on tags, which are not in the inventory: it never matches", rule.ID)
+		}
+	}
+
+	defer handler.Flush()
+
+	planner := &Planner{
+		Rules:      rules,
+		Versioning: inventory.Versioning,
+		Clock:      options.Clock,
+	}
+	for _, history := range keyHistories(SortVersions(slices.Clone(inventory.Versions))) {
+		for _, action := range planner.PlanVersions(history) {
+			handler.Handle(action)
+		}
+	}
+	return nil
+}
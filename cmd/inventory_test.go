@@ -0,0 +1,86 @@
+package cmd_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+)
+
+var inventoryVersions = []cmd.Version{
+	{Key: "logs/a.log", IsLatest: true, LastModified: date("2024-01-11"), VersionId: "v2", Size: 100},
+	{Key: "logs/a.log", IsLatest: false, LastModified: date("2024-01-01"), VersionId: "v1", Size: 200},
+	{Key: "logs/b.log", IsLatest: true, LastModified: date("2024-01-21"), VersionId: "v4", DeleteMarker: true},
+	{Key: "logs/b.log", IsLatest: false, LastModified: date("2024-01-01"), VersionId: "v3", Size: 300},
+}
+
+func TestReadInventory(t *testing.T) {
+	for _, path := range []string{"../testdata/inventory.jsonl", "../testdata/inventory.csv"} {
+		t.Run(path, func(t *testing.T) {
+			inventory, err := cmd.ReadInventory(path)
+			require.NoError(t, err)
+			require.Equal(t, types.BucketVersioningStatusEnabled, inventory.Versioning)
+			require.Equal(t, inventoryVersions, inventory.Versions)
+		})
+	}
+}
+
+func TestReadInventoryUnversioned(t *testing.T) {
+	versions, err := cmd.ReadInventoryCSV(strings.NewReader("key,lastmodified,islatest\nlogs/a.log,2024-01-01T00:00:00Z,true\n"))
+	require.NoError(t, err)
+	require.Equal(t, []cmd.Version{{Key: "logs/a.log", IsLatest: true, LastModified: date("2024-01-01"), VersionId: cmd.NullVersionId}}, versions)
+	require.Equal(t, types.BucketVersioningStatus(""), cmd.NewInventory(versions).Versioning)
+}
+
+func TestReadInventoryErrors(t *testing.T) {
+	_, err := cmd.ReadInventoryJSONL(strings.NewReader(`{"Key": "a", "IsLatest": true, "LastModified": "2024-01-01T00:00:00Z"}` + "\n" + `{"Key": "b", "LastModified": "2024-01-01T00:00:00Z"}`))
+	require.EqualError(t, err, "line 2: IsLatest is required")
+
+	_, err = cmd.ReadInventoryJSONL(strings.NewReader(`{"Key": "a", "IsLatest": true, "LastModified": "2024-01-01T00:00:00Z", "Owner": "me"}`))
+	require.ErrorContains(t, err, `line 1: json: unknown field "Owner"`)
+
+	_, err = cmd.ReadInventoryCSV(strings.NewReader("Key,IsLatest,LastModified\na,true,2024-01-01T00:00:00Z\nb,yes,2024-01-01T00:00:00Z\n"))
+	require.ErrorContains(t, err, "line 3: IsLatest:")
+
+	_, err = cmd.ReadInventoryCSV(strings.NewReader("Key,Owner\n"))
+	require.EqualError(t, err, "line 1: unknown column Owner")
+
+	_, err = cmd.ReadInventoryCSV(strings.NewReader("Key,IsLatest\na,true\n"))
+	require.EqualError(t, err, "line 2: LastModified is required")
+}
+
+func TestDryRunInventory(t *testing.T) {
+	cfg := LoadConfig("../testdata/rules_with_10_days_and_noncurrent_20_days.json")
+	inventory := cmd.NewInventory(inventoryVersions)
+
+	// No client is needed to plan from an inventory
+	plan, err := cmd.DryRun(nil, bucket, cfg, func(o *cmd.Options) {
+		o.Inventory = inventory
+		o.Clock = cmd.FixedClock(date("2024-01-31"))
+	})
+	require.NoError(t, err)
+	require.Equal(t, []cmd.Action{
+		{Type: cmd.ActionExpire, Key: "logs/a.log", VersionId: "v2", RuleID: "Current", Reason: cmd.ReasonExpiration},
+		{Type: cmd.ActionDeleteVersion, Key: "logs/a.log", VersionId: "v1", RuleID: "Noncurrent", Reason: cmd.ReasonNoncurrentDays},
+	}, plan.Actions)
+}
+
+func TestMemInventory(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	memAt(client, 2)
+	memPut(t, client, "key1")
+	inventory := cmd.NewInventory(memVersions(t, client))
+
+	// A recorded listing can be stale, it is never applied on the bucket
+	cfg := LoadConfig("../testdata/rule_with_expiration_1_days.json")
+	err := cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Inventory = inventory })
+	require.EqualError(t, err, "an inventory can only be planned with DryRun, not executed")
+	require.Equal(t, 1, len(memVersions(t, client)))
+
+	err = cmd.Execute(nil, bucket, cfg, func(o *cmd.Options) { o.Inventory = inventory })
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// ListObjectVersionsPager is the subset of s3.ListObjectVersionsPaginator used by
+// the VersionIterator.
+type ListObjectVersionsPager interface {
+	HasMorePages() bool
+	NextPage(context.Context, ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+}
+
+// VersionIterator yields the complete version history of each key of a bucket,
+// whatever the page boundaries of the listing are. The versions of a key can be
+// split across two pages: they are only yielded once the next key shows up or
+// the listing is over.
+type VersionIterator struct {
+	pager   ListObjectVersionsPager
+	pending []Version
+	ready   [][]Version
+}
+
+func NewVersionIterator(pager ListObjectVersionsPager) *VersionIterator {
+	return &VersionIterator{pager: pager}
+}
+
+// Next returns the versions of the next key, newest first, or nil once every key
+// has been returned.
+func (it *VersionIterator) Next(ctx context.Context) ([]Version, error) {
+	for len(it.ready) == 0 {
+		if !it.pager.HasMorePages() {
+			if len(it.pending) == 0 {
+				return nil, nil
+			}
+			it.ready = append(it.ready, it.pending)
+			it.pending = nil
+			break
+		}
+
+		output, err := it.pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Keys are listed in order, so only the last key of the previous page
+		// can still be pending.
+		versions := SortVersions(append(append([]Version{}, it.pending...), ToVersions(output)...))
+		it.pending = nil
+		for start := 0; start < len(versions); {
+			end := start + 1
+			for end < len(versions) && versions[end].Key == versions[start].Key {
+				end++
+			}
+			if end == len(versions) {
+				it.pending = versions[start:end]
+			} else {
+				it.ready = append(it.ready, versions[start:end])
+			}
+			start = end
+		}
+	}
+
+	versions := it.ready[0]
+	it.ready = it.ready[1:]
+	return versions, nil
+}
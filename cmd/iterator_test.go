@@ -0,0 +1,116 @@
+package cmd_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+)
+
+type staticPager struct {
+	pages []*s3.ListObjectVersionsOutput
+}
+
+func (p *staticPager) HasMorePages() bool {
+	return len(p.pages) > 0
+}
+
+func (p *staticPager) NextPage(context.Context, ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	page := p.pages[0]
+	p.pages = p.pages[1:]
+	return page, nil
+}
+
+var t0 = time.Date(2023, time.November, 29, 12, 0, 0, 0, time.UTC)
+
+func objectVersion(key, versionId string, hour int, isLatest bool) types.ObjectVersion {
+	return types.ObjectVersion{Key: aws.String(key), VersionId: aws.String(versionId), LastModified: aws.Time(t0.Add(time.Duration(hour) * time.Hour)), IsLatest: isLatest}
+}
+
+func deleteMarker(key, versionId string, hour int, isLatest bool) types.DeleteMarkerEntry {
+	return types.DeleteMarkerEntry{Key: aws.String(key), VersionId: aws.String(versionId), LastModified: aws.Time(t0.Add(time.Duration(hour) * time.Hour)), IsLatest: isLatest}
+}
+
+func collectVersionIds(t *testing.T, pager cmd.ListObjectVersionsPager) [][]string {
+	iterator := cmd.NewVersionIterator(pager)
+	keys := [][]string{}
+	for {
+		versions, err := iterator.Next(context.TODO())
+		require.NoError(t, err)
+		if versions == nil {
+			return keys
+		}
+		ids := []string{}
+		for _, version := range versions {
+			require.Equal(t, versions[0].Key, version.Key)
+			ids = append(ids, version.VersionId)
+		}
+		keys = append(keys, ids)
+	}
+}
+
+func TestVersionIteratorEmptyBucket(t *testing.T) {
+	pager := &staticPager{pages: []*s3.ListObjectVersionsOutput{{}}}
+	require.Empty(t, collectVersionIds(t, pager))
+}
+
+func TestVersionIteratorSinglePage(t *testing.T) {
+	pager := &staticPager{pages: []*s3.ListObjectVersionsOutput{{
+		Versions: []types.ObjectVersion{
+			objectVersion("key1", "v2", 2, false),
+			objectVersion("key1", "v1", 1, false),
+			objectVersion("key2", "v3", 1, true),
+		},
+		DeleteMarkers: []types.DeleteMarkerEntry{
+			deleteMarker("key1", "d1", 3, true),
+		},
+	}}}
+	require.Equal(t, [][]string{{"d1", "v2", "v1"}, {"v3"}}, collectVersionIds(t, pager))
+}
+
+func TestVersionIteratorKeySplitAcrossPages(t *testing.T) {
+	pager := &staticPager{pages: []*s3.ListObjectVersionsOutput{
+		{
+			Versions: []types.ObjectVersion{
+				objectVersion("key1", "v1", 1, true),
+				objectVersion("key2", "v4", 4, false),
+			},
+			DeleteMarkers: []types.DeleteMarkerEntry{
+				deleteMarker("key2", "d5", 5, true),
+			},
+		},
+		{
+			Versions: []types.ObjectVersion{
+				objectVersion("key2", "v2", 2, false),
+			},
+			DeleteMarkers: []types.DeleteMarkerEntry{
+				deleteMarker("key2", "d3", 3, false),
+			},
+		},
+		{
+			Versions: []types.ObjectVersion{
+				objectVersion("key2", "v1", 1, false),
+				objectVersion("key3", "v1", 1, true),
+			},
+		},
+	}}
+	require.Equal(t, [][]string{{"v1"}, {"d5", "v4", "d3", "v2", "v1"}, {"v1"}}, collectVersionIds(t, pager))
+}
+
+func TestVersionIteratorLatestFirstOnSameDate(t *testing.T) {
+	pager := &staticPager{pages: []*s3.ListObjectVersionsOutput{{
+		Versions: []types.ObjectVersion{
+			objectVersion("key1", "v1", 1, false),
+		},
+		DeleteMarkers: []types.DeleteMarkerEntry{
+			deleteMarker("key1", "d1", 1, true),
+		},
+	}}}
+	require.Equal(t, [][]string{{"d1", "v1"}}, collectVersionIds(t, pager))
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// Format is the format of a configuration file.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	// FormatXML is the S3 LifecycleConfiguration document, as used by
+	// aws s3api put-bucket-lifecycle-configuration.
+	FormatXML Format = "xml"
+	// FormatYAML uses the same field names as JSON, and allows comments.
+	FormatYAML Format = "yaml"
+)
+
+// FormatFromPath guesses the format of a configuration file from its
+// extension, JSON being the default.
+func FormatFromPath(configPath string) Format {
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".xml":
+		return FormatXML
+	case ".yaml", ".yml":
+		return FormatYAML
+	default:
+		return FormatJSON
+	}
+}
+
+func LoadConfig(configPath string) (*config.BucketLifecycleConfiguration, error) {
+	return LoadConfigFormat(configPath, "")
+}
+
+// LoadConfigFormat loads a configuration file in the given format, or in the
+// format guessed from its extension when empty.
+func LoadConfigFormat(configPath string, format Format) (*config.BucketLifecycleConfiguration, error) {
+	if format == "" {
+		format = FormatFromPath(configPath)
+	}
+	if format != FormatJSON && format != FormatXML && format != FormatYAML {
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+
+	// Open our jsonFile
+	jsonFile, err := os.Open(configPath)
+	// if we os.Open returns an error then handle it
+	if err != nil {
+		return nil, err
+	}
+
+	defer jsonFile.Close()
+
+	byteValue, err := io.ReadAll(jsonFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var blc config.BucketLifecycleConfiguration
+
+	switch format {
+	case FormatXML:
+		if err := decodeXML(byteValue, &blc); err != nil {
+			return nil, err
+		}
+	case FormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(byteValue))
+		decoder.KnownFields(true)
+		// An empty document is left to the validation
+		if err := decoder.Decode(&blc); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	default:
+		if err := decodeJSONStrict(byteValue, &blc); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateConfig(&blc); err != nil {
+		return nil, err
+	}
+
+	return &blc, nil
+
+}
+
+// xmlLifecycleConfiguration also reads the Prefix of the rules, deprecated in
+// favor of Filter but still valid in S3 documents.
+type xmlLifecycleConfiguration struct {
+	Rules []struct {
+		config.Rule
+		Prefix *string `xml:"Prefix"`
+	} `xml:"Rule"`
+}
+
+func decodeXML(data []byte, blc *config.BucketLifecycleConfiguration) error {
+	var document xmlLifecycleConfiguration
+	if err := xml.Unmarshal(data, &document); err != nil {
+		return err
+	}
+
+	blc.Rules = make([]config.Rule, 0, len(document.Rules))
+	for _, rule := range document.Rules {
+		if rule.Prefix != nil {
+			if rule.Filter != nil {
+				return fmt.Errorf("rule %s: Prefix and Filter cannot be used together", rule.ID)
+			}
+			// An empty prefix matches every object
+			if *rule.Prefix != "" {
+				rule.Filter = &config.Filter{Prefix: rule.Prefix}
+			}
+		}
+		blc.Rules = append(blc.Rules, rule.Rule)
+	}
+	return nil
+}
+
+// validateConfig runs the checks every configuration goes through, whatever its
+// source.
+func validateConfig(blc *config.BucketLifecycleConfiguration) error {
+	validate := validator.New()
+	if err := validate.Struct(blc); err != nil {
+		return err
+	}
+
+	return blc.Validate()
+}
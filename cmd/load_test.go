@@ -0,0 +1,151 @@
+package cmd_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+	bconfig "github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+func TestFormatFromPath(t *testing.T) {
+	require.Equal(t, cmd.FormatJSON, cmd.FormatFromPath("lifecycle.json"))
+	require.Equal(t, cmd.FormatXML, cmd.FormatFromPath("lifecycle.XML"))
+	require.Equal(t, cmd.FormatYAML, cmd.FormatFromPath("lifecycle.yml"))
+	require.Equal(t, cmd.FormatJSON, cmd.FormatFromPath("lifecycle"))
+}
+
+func TestLoadConfigXML(t *testing.T) {
+	for _, name := range []string{
+		"rule_with_expiration_and_filter",
+		"rule_with_expiration_and_tags",
+		"rules_with_noncurrent_version_transition_and_expiration",
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, LoadConfig("../testdata/"+name+".json"), LoadConfig("../testdata/"+name+".xml"))
+		})
+	}
+}
+
+func TestLoadConfigXMLPrefix(t *testing.T) {
+	// The Prefix of the rule is deprecated in favor of Filter
+	cfg := LoadConfig("../testdata/rule_with_expiration_and_prefix.xml")
+	require.Equal(t, &bconfig.Filter{Prefix: aws.String("logs/")}, cfg.Rules[0].Filter)
+
+	path := filepath.Join(t.TempDir(), "lifecycle.xml")
+	require.NoError(t, os.WriteFile(path, []byte(`<LifecycleConfiguration><Rule><ID>r1</ID><Prefix>logs/</Prefix><Filter><Prefix>logs/</Prefix></Filter>`+
+		`<Status>Enabled</Status><Expiration><Days>1</Days></Expiration></Rule></LifecycleConfiguration>`), 0o600))
+	_, err := cmd.LoadConfig(path)
+	require.EqualError(t, err, "rule r1: Prefix and Filter cannot be used together")
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	for _, name := range []string{
+		"rule_with_expiration_and_filter",
+		"rule_with_expiration_and_tags",
+		"rule_with_expiration_date",
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, LoadConfig("../testdata/"+name+".json"), LoadConfig("../testdata/"+name+".yaml"))
+		})
+	}
+}
+
+func TestLoadConfigYAMLUnknownField(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_rule_with_unknown_field.yaml")
+	require.ErrorContains(t, err, "line 5: field Prefx not found")
+}
+
+func TestLoadConfigXMLValidation(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_rule_with_expiration_date_not_midnight.xml")
+	require.ErrorContains(t, err, "midnight UTC")
+
+	// S3 transitions target a storage class, SOS ones a bucket
+	_, err = cmd.LoadConfig("../testdata/invalid_rule_with_storage_class_transition.xml")
+	require.ErrorContains(t, err, "Bucket")
+}
+
+func TestLoadConfigExpiration(t *testing.T) {
+	cfg, err := cmd.LoadConfig("../testdata/rule_with_expiration_date_only.json")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2999, time.January, 1, 0, 0, 0, 0, time.UTC), cfg.Rules[0].Expiration.Date.Time)
+
+	cfg, err = cmd.LoadConfig("../testdata/rule_with_expiration_date_only.yaml")
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2999, time.January, 1, 0, 0, 0, 0, time.UTC), cfg.Rules[0].Expiration.Date.Time)
+
+	_, err = cmd.LoadConfig("../testdata/invalid_rule_with_empty_expiration.json")
+	require.EqualError(t, err, "rule ExampleRule: Expiration has neither Date, Days nor ExpiredObjectDeleteMarker")
+}
+
+func TestLoadConfigFormatFlag(t *testing.T) {
+	_, err := cmd.LoadConfigFormat("../testdata/rule_with_expiration_and_filter.json", cmd.FormatXML)
+	require.Error(t, err)
+
+	_, err = cmd.LoadConfigFormat("../testdata/rule_with_expiration_and_filter.json", "toml")
+	require.ErrorContains(t, err, "unknown configuration format")
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_rule_with_unknown_field.json")
+	var decodeErr *cmd.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, 7, decodeErr.Line)
+	require.Equal(t, 17, decodeErr.Column)
+	require.Equal(t, "Rules[0].AbortIncompleteMultipartUpload", decodeErr.Path)
+	require.ErrorContains(t, err, `did you mean "DaysAfterInitiation"?`)
+}
+
+func TestLoadConfigFieldCase(t *testing.T) {
+	// The field names are case-sensitive, like in the schema
+	path := filepath.Join(t.TempDir(), "lifecycle.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"Rules": [{"ID": "r1", "Status": "Enabled", "AbortIncompleteMultipartUpload": {"DaysAfterinitiation": 7}}]}`), 0o600))
+	_, err := cmd.LoadConfig(path)
+	var decodeErr *cmd.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, "Rules[0].AbortIncompleteMultipartUpload", decodeErr.Path)
+	require.ErrorContains(t, err, `unknown field "DaysAfterinitiation", did you mean "DaysAfterInitiation"?`)
+}
+
+func TestLoadConfigWrongType(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_rule_with_wrong_type.json")
+	var decodeErr *cmd.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, 7, decodeErr.Line)
+	require.Equal(t, 25, decodeErr.Column)
+	require.Equal(t, "Rules[0].Expiration.Days", decodeErr.Path)
+	require.ErrorContains(t, err, "cannot use a JSON string as int")
+}
+
+func TestLoadConfigFractionalNumber(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lifecycle.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"Rules": [{"ID": "r1", "Status": "Enabled", "Expiration": {"Days": 1.5}}]}`), 0o600))
+	_, err := cmd.LoadConfig(path)
+	var decodeErr *cmd.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, "Rules[0].Expiration.Days", decodeErr.Path)
+	require.Equal(t, 69, decodeErr.Column)
+	require.ErrorContains(t, err, "cannot use a JSON number 1.5 as int")
+}
+
+func TestLoadConfigSyntaxError(t *testing.T) {
+	_, err := cmd.LoadConfig("../testdata/invalid_syntax.json")
+	var decodeErr *cmd.DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, 5, decodeErr.Line)
+	require.Equal(t, 32, decodeErr.Column)
+}
+
+func TestLoadConfigFixtures(t *testing.T) {
+	paths, err := filepath.Glob("../testdata/rule*.json")
+	require.NoError(t, err)
+	for _, path := range paths {
+		_, err := cmd.LoadConfig(path)
+		require.NoError(t, err, path)
+	}
+}
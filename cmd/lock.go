@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// ObjectLock is the Object Lock protection of a version.
+type ObjectLock struct {
+	Mode        types.ObjectLockRetentionMode
+	RetainUntil *time.Time
+	LegalHold   bool
+}
+
+// LockFetcher returns the Object Lock protection of a version.
+type LockFetcher func(version Version) (ObjectLock, error)
+
+// protection returns the reason why the version cannot be removed, if any. A
+// governance retention can be bypassed when explicitly allowed.
+func (l ObjectLock) protection(now time.Time, bypassGovernance bool) (Reason, bool) {
+	if l.LegalHold {
+		return ReasonLegalHold, true
+	}
+	if l.RetainUntil != nil && l.RetainUntil.After(now) &&
+		!(l.Mode == types.ObjectLockRetentionModeGovernance && bypassGovernance) {
+		return ReasonRetention, true
+	}
+	return "", false
+}
+
+// objectLockEnabled reports whether Object Lock is enabled on the bucket.
+func objectLockEnabled(client S3Client, bucket *string) bool {
+	output, err := client.GetObjectLockConfiguration(context.TODO(), &s3.GetObjectLockConfigurationInput{Bucket: bucket})
+	if err != nil {
+		if !isAPIError(err, "ObjectLockConfigurationNotFoundError") {
+			log.Printf("[object lock] cannot get the configuration of %s: %v", *bucket, err)
+		}
+		return false
+	}
+	return output.ObjectLockConfiguration != nil && output.ObjectLockConfiguration.ObjectLockEnabled == types.ObjectLockEnabledEnabled
+}
+
+// newLockFetcher gets the protection of the versions with GetObjectRetention and
+// GetObjectLegalHold.
+func newLockFetcher(client S3Client, bucket *string) LockFetcher {
+	return func(version Version) (ObjectLock, error) {
+		lock := ObjectLock{}
+		retention, err := client.GetObjectRetention(context.TODO(), &s3.GetObjectRetentionInput{Bucket: bucket, Key: aws.String(version.Key), VersionId: aws.String(version.VersionId)})
+		if err != nil && !isAPIError(err, "NoSuchObjectLockConfiguration") {
+			return lock, err
+		}
+		if err == nil && retention.Retention != nil {
+			lock.Mode = retention.Retention.Mode
+			lock.RetainUntil = retention.Retention.RetainUntilDate
+		}
+
+		legalHold, err := client.GetObjectLegalHold(context.TODO(), &s3.GetObjectLegalHoldInput{Bucket: bucket, Key: aws.String(version.Key), VersionId: aws.String(version.VersionId)})
+		if err != nil && !isAPIError(err, "NoSuchObjectLockConfiguration") {
+			return lock, err
+		}
+		if err == nil && legalHold.LegalHold != nil {
+			lock.LegalHold = legalHold.LegalHold.Status == types.ObjectLockLegalHoldStatusOn
+		}
+		return lock, nil
+	}
+}
+
+func isAPIError(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
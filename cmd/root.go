@@ -3,33 +3,72 @@ package cmd
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
 	"github.com/exoscale/sos-client-bucket-lifecycle/sos"
 )
 
 var (
-	bucket     string
-	accessKey  string
-	secretKey  string
-	zone       string
-	configPath string
+	bucket      string
+	accessKey   string
+	secretKey   string
+	zone        string
+	configPath  string
+	format      string
+	fromBucket  bool
+	dryRun      bool
+	concurrency int
+	now         string
+	inventory   string
+	bypass      bool
 )
 
 func CliExecute() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(ValidateCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "schema":
+			os.Exit(SchemaCommand(os.Args[2:], os.Stdout, os.Stderr))
+		case "simulate":
+			os.Exit(SimulateCommand(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	flag.Parse()
 
+	var clock Clock
+	if now != "" {
+		// Applying the rules as of a later date would remove objects before they are due
+		if !dryRun && inventory == "" {
+			log.Fatalf("--now can only be used along with --dry-run or --inventory")
+		}
+		date, err := ParseDate(now)
+		if err != nil {
+			log.Fatalf("Invalid --now date: %v", err)
+		}
+		log.Printf("Planning the rules as of %s", date.Format(time.RFC3339))
+		clock = FixedClock(date)
+	}
+
+	if inventory != "" {
+		planInventory(clock)
+		return
+	}
+
 	client, err := sos.NewStorageClient(context.TODO(), zone, accessKey, secretKey)
 	if err != nil {
 		log.Fatalf("Cannot create SOS client on zone %s with acccess key %s\n %v", "", accessKey, err)
 	}
-	cfg, err := LoadConfig(configPath)
-	if err != nil {
-		log.Fatalf("Cannot load configuration: %s\n %v", configPath, err)
+	if fromBucket && configPath != "" {
+		log.Fatalf("--config and --from-bucket cannot be used together")
 	}
 
 	location, err := client.GetBucketLocation(context.TODO(), &s3.GetBucketLocationInput{Bucket: &bucket})
@@ -44,9 +83,45 @@ func CliExecute() {
 		}
 	}
 
-	log.Printf("Executing bucket lifecycle configuration")
-	if err := Execute(client, bucket, *cfg); err != nil {
-		log.Fatalf("Error: %v", err)
+	var cfg *config.BucketLifecycleConfiguration
+	if fromBucket {
+		cfg, err = LoadBucketConfig(client, bucket)
+		if err != nil {
+			log.Fatalf("Cannot load the lifecycle configuration of the bucket %s\n %v", bucket, err)
+		}
+	} else {
+		cfg, err = LoadConfigFormat(configPath, Format(format))
+		if err != nil {
+			log.Fatalf("Cannot load configuration: %s\n %v", configPath, err)
+		}
+	}
+
+	if dryRun {
+		log.Printf("Planning bucket lifecycle configuration (dry run)")
+		plan, err := DryRun(client, bucket, *cfg, func(o *Options) {
+			o.BypassGovernanceRetention = bypass
+			o.Clock = clock
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		for _, action := range plan.Actions {
+			fmt.Println(action)
+		}
+		log.Printf("%d action(s) planned", len(plan.Actions))
+	} else {
+		log.Printf("Executing bucket lifecycle configuration")
+		err := Execute(client, bucket, *cfg, func(o *Options) {
+			o.Concurrency = concurrency
+			o.BypassGovernanceRetention = bypass
+			o.Clock = clock
+			o.TargetClient = func(zone string) (S3Client, error) {
+				return sos.NewStorageClient(context.TODO(), zone, accessKey, secretKey)
+			}
+		})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -67,10 +142,46 @@ func CliExecute() {
 	log.Printf("Done")
 }
 
+// planInventory prints the actions planned on a recorded listing of the bucket,
+// without credentials nor connection to SOS.
+func planInventory(clock Clock) {
+	if fromBucket {
+		log.Fatalf("--inventory and --from-bucket cannot be used together")
+	}
+	cfg, err := LoadConfigFormat(configPath, Format(format))
+	if err != nil {
+		log.Fatalf("Cannot load configuration: %s\n %v", configPath, err)
+	}
+	inv, err := ReadInventory(inventory)
+	if err != nil {
+		log.Fatalf("Cannot read the inventory: %v", err)
+	}
+
+	log.Printf("Planning bucket lifecycle configuration from the inventory %s (dry run)", inventory)
+	plan, err := DryRun(nil, bucket, *cfg, func(o *Options) {
+		o.Clock = clock
+		o.Inventory = inv
+	})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	for _, action := range plan.Actions {
+		fmt.Println(action)
+	}
+	log.Printf("%d action(s) planned", len(plan.Actions))
+}
+
 func init() {
 	flag.StringVar(&bucket, "bucket", "", "Bucket name")
 	flag.StringVar(&accessKey, "access-key", "", "Access Key")
 	flag.StringVar(&secretKey, "secret-key", "", "Secret key")
 	flag.StringVar(&zone, "zone", "ch-gva-2", "Bucket zone")
-	flag.StringVar(&configPath, "config", "", "Bucket-lifecycle configuration file path (.json)")
+	flag.StringVar(&configPath, "config", "", "Bucket-lifecycle configuration file path (.json, .xml or .yaml)")
+	flag.StringVar(&format, "format", "", "Configuration file format (json, xml or yaml), guessed from the file extension by default")
+	flag.BoolVar(&fromBucket, "from-bucket", false, "Apply the lifecycle configuration stored on the bucket instead of a configuration file")
+	flag.BoolVar(&dryRun, "dry-run", false, "List the actions without applying them")
+	flag.BoolVar(&bypass, "bypass-governance-retention", false, "Remove the versions under an Object Lock governance retention")
+	flag.StringVar(&now, "now", "", "Date the rules are planned as of (2006-01-02 or RFC 3339), with --dry-run or --inventory only")
+	flag.StringVar(&inventory, "inventory", "", "Plan the rules offline from a recorded listing of the bucket (.jsonl or .csv), implies --dry-run")
+	flag.IntVar(&concurrency, "concurrency", 4, "Number of workers deleting objects and aborting uploads")
 }
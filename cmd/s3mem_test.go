@@ -0,0 +1,455 @@
+package cmd_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+	"github.com/exoscale/sos-client-bucket-lifecycle/s3mem"
+)
+
+var _ cmd.S3Client = (*s3mem.Client)(nil)
+
+// memBucket creates an in-memory bucket named like the one of the MinIO tests.
+func memBucket(t *testing.T, input *s3.CreateBucketInput) *s3mem.Client {
+	client := s3mem.New()
+	input.Bucket = &bucket
+	_, err := client.CreateBucket(context.TODO(), input)
+	require.NoError(t, err)
+	return client
+}
+
+// memAt dates the next writes of the client the given number of days ago.
+func memAt(client *s3mem.Client, daysAgo float64) {
+	date := time.Now().Add(-time.Duration(daysAgo * float64(24*time.Hour)))
+	client.Now = func() time.Time { return date }
+}
+
+func memPut(t *testing.T, client *s3mem.Client, key string) *s3.PutObjectOutput {
+	output, err := client.PutObject(context.TODO(), &s3.PutObjectInput{Bucket: &bucket, Key: &key, Body: strings.NewReader("toto")})
+	require.NoError(t, err)
+	return output
+}
+
+func memDelete(t *testing.T, client *s3mem.Client, key string) {
+	_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+	require.NoError(t, err)
+}
+
+func memVersions(t *testing.T, client *s3mem.Client) []cmd.Version {
+	versions := []cmd.Version{}
+	paginator := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{Bucket: &bucket})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(context.TODO())
+		require.NoError(t, err)
+		versions = append(versions, cmd.ToVersions(output)...)
+	}
+	return cmd.SortVersions(versions)
+}
+
+func TestMemExpiration1Days(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	_, err := client.PutBucketVersioning(context.TODO(), &s3.PutBucketVersioningInput{Bucket: &bucket, VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusEnabled}})
+	require.NoError(t, err)
+	memAt(client, 2)
+	memPut(t, client, "key1")
+	memAt(client, 0)
+	memPut(t, client, "key2")
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_expiration_1_days.json")))
+	versions := memVersions(t, client)
+	require.Equal(t, 3, len(versions))
+	require.True(t, versions[0].DeleteMarker)
+	require.Equal(t, "key1", versions[0].Key)
+	require.False(t, versions[2].DeleteMarker)
+	require.Equal(t, "key2", versions[2].Key)
+}
+
+func TestMemNoncurrentDays1Days(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	memAt(client, 3)
+	memPut(t, client, "documents/key1")
+	memAt(client, 2)
+	memPut(t, client, "documents/key1")
+	memAt(client, 0.5)
+	memPut(t, client, "documents/key1")
+
+	// The expiration adds a delete marker, the oldest version has been
+	// noncurrent for 2 days and the second one for half a day.
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_expiration_non_current_days_1_days.json")))
+	versions := memVersions(t, client)
+	require.Equal(t, 3, len(versions))
+	require.True(t, versions[0].DeleteMarker)
+}
+
+func TestMemExpiredObjectDeleteMarker(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	first := memPut(t, client, "key1")
+	memDelete(t, client, "key1")
+	_, err := client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{Bucket: &bucket, Key: aws.String("key1"), VersionId: first.VersionId})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(memVersions(t, client)))
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_expiration_expired_object_delete_marker_true.json")))
+	require.Empty(t, memVersions(t, client))
+}
+
+func TestMemUnversionedBucket(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	memPut(t, client, "key1")
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_expiration_0_days.json")))
+	require.Empty(t, memVersions(t, client))
+}
+
+func TestMemSuspendedBucket(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	memPut(t, client, "key1")
+	_, err := client.PutBucketVersioning(context.TODO(), &s3.PutBucketVersioningInput{Bucket: &bucket, VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusSuspended}})
+	require.NoError(t, err)
+	memPut(t, client, "key1")
+
+	// The null version is replaced by a null delete marker
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_expiration_0_days.json")))
+	versions := memVersions(t, client)
+	require.Equal(t, 2, len(versions))
+	require.True(t, versions[0].DeleteMarker)
+	require.Equal(t, s3mem.NullVersionId, versions[0].VersionId)
+}
+
+func TestMemAbortIncompleteMultipartUpload(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	memAt(client, 8)
+	_, err := client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{Bucket: &bucket, Key: aws.String("key1")})
+	require.NoError(t, err)
+	memAt(client, 6)
+	_, err = client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{Bucket: &bucket, Key: aws.String("key2")})
+	require.NoError(t, err)
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_abort_incomplete_multipart_upload_1_days.json")))
+	output, err := client.ListMultipartUploads(context.TODO(), &s3.ListMultipartUploadsInput{Bucket: &bucket})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(output.Uploads))
+	require.Equal(t, "key2", *output.Uploads[0].Key)
+}
+
+func TestMemTransitionDeleteSource(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String("archive")})
+	require.NoError(t, err)
+	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:   &bucket,
+		Key:      aws.String("key1"),
+		Body:     strings.NewReader("toto"),
+		Metadata: map[string]string{"origin": "ci"},
+		Tagging:  aws.String("lifecycle=ephemeral"),
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_transition.json")))
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{Bucket: aws.String("archive"), Key: aws.String("key1")})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), head.ContentLength)
+	require.Equal(t, "ci", head.Metadata["origin"])
+	require.Equal(t, "v000001", head.Metadata["source-version-id"])
+	tagging, err := client.GetObjectTagging(context.TODO(), &s3.GetObjectTaggingInput{Bucket: aws.String("archive"), Key: aws.String("key1")})
+	require.NoError(t, err)
+	require.Equal(t, "ephemeral", *tagging.TagSet[0].Value)
+
+	versions := memVersions(t, client)
+	require.Equal(t, 2, len(versions))
+	require.True(t, versions[0].DeleteMarker)
+}
+
+// multipartSource reports the objects of the source bucket as uploaded in parts.
+type multipartSource struct {
+	*s3mem.Client
+}
+
+func (c multipartSource) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	output, err := c.Client.HeadObject(ctx, params, optFns...)
+	if err == nil && *params.Bucket == bucket {
+		output.ETag = aws.String(`"0123456789abcdef0123456789abcdef-2"`)
+	}
+	return output, err
+}
+
+func TestMemTransitionMultipartSourceOverwritesTarget(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String("archive")})
+	require.NoError(t, err)
+	// An older object of the same size must not be taken for the copy
+	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{Bucket: aws.String("archive"), Key: aws.String("key1"), Body: strings.NewReader("tata")})
+	require.NoError(t, err)
+	memPut(t, client, "key1")
+
+	require.NoError(t, cmd.Execute(multipartSource{client}, bucket, LoadConfig("../testdata/rule_with_transition.json")))
+	object, err := client.GetObject(context.TODO(), &s3.GetObjectInput{Bucket: aws.String("archive"), Key: aws.String("key1")})
+	require.NoError(t, err)
+	body, err := io.ReadAll(object.Body)
+	require.NoError(t, err)
+	require.Equal(t, "toto", string(body))
+	require.Empty(t, memVersions(t, client))
+}
+
+func TestMemTransitionKeepSourceCopiedOnce(t *testing.T) {
+	for _, input := range []*s3.CreateBucketInput{{ObjectLockEnabledForBucket: true}, {}} {
+		client := memBucket(t, input)
+		_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String("archive"), ObjectLockEnabledForBucket: true})
+		require.NoError(t, err)
+		memPut(t, client, "key1")
+
+		// The source stays current, the following runs find its copy
+		for i := 0; i < 3; i++ {
+			require.NoError(t, cmd.Execute(multipartSource{client}, bucket, LoadConfig("../testdata/rule_with_transition_keep_source.json")))
+		}
+		output, err := client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{Bucket: aws.String("archive")})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(output.Versions))
+		require.Equal(t, 1, len(memVersions(t, client)))
+	}
+}
+
+func TestMemTransitionMissingTargetKeepsSource(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	memPut(t, client, "key1")
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_transition.json")))
+	versions := memVersions(t, client)
+	require.Equal(t, 1, len(versions))
+	require.False(t, versions[0].DeleteMarker)
+}
+
+func TestMemTransitionTargetZone(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	memPut(t, client, "key1")
+	target := s3mem.New()
+	_, err := target.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String("archive")})
+	require.NoError(t, err)
+
+	zones := []string{}
+	err = cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_transition_and_expiration.json"), func(o *cmd.Options) {
+		o.TargetClient = func(zone string) (cmd.S3Client, error) {
+			zones = append(zones, zone)
+			return target, nil
+		}
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"de-fra-1"}, zones)
+	_, err = target.HeadObject(context.TODO(), &s3.HeadObjectInput{Bucket: aws.String("archive"), Key: aws.String("key1")})
+	require.NoError(t, err)
+}
+
+func TestMemNoncurrentVersionTransition(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	_, err := client.CreateBucket(context.TODO(), &s3.CreateBucketInput{Bucket: aws.String("archive")})
+	require.NoError(t, err)
+	first := memPut(t, client, "key1")
+	memPut(t, client, "key1")
+
+	require.NoError(t, cmd.Execute(client, bucket, LoadConfig("../testdata/rule_with_noncurrent_version_transition.json")))
+	_, err = client.HeadObject(context.TODO(), &s3.HeadObjectInput{Bucket: aws.String("archive"), Key: aws.String("key1/" + *first.VersionId)})
+	require.NoError(t, err)
+	versions := memVersions(t, client)
+	require.Equal(t, 1, len(versions))
+	require.True(t, versions[0].IsLatest)
+}
+
+func TestMemLegalHold(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	first := memPut(t, client, "documents/key1")
+	memPut(t, client, "documents/key1")
+	_, err := client.PutObjectLegalHold(context.TODO(), &s3.PutObjectLegalHoldInput{
+		Bucket:    &bucket,
+		Key:       aws.String("documents/key1"),
+		VersionId: first.VersionId,
+		LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOn},
+	})
+	require.NoError(t, err)
+
+	cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
+	plan, err := cmd.DryRun(client, bucket, cfg)
+	require.NoError(t, err)
+	require.Equal(t, cmd.ActionSkip, plan.Actions[1].Type)
+	require.Equal(t, cmd.ReasonLegalHold, plan.Actions[1].Reason)
+
+	require.NoError(t, cmd.Execute(client, bucket, cfg))
+	require.Equal(t, 3, len(memVersions(t, client)))
+}
+
+func TestMemGovernanceRetentionBypass(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	_, err := client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:                    &bucket,
+		Key:                       aws.String("documents/key1"),
+		Body:                      strings.NewReader("toto"),
+		ObjectLockMode:            types.ObjectLockModeGovernance,
+		ObjectLockRetainUntilDate: aws.Time(time.Now().Add(24 * time.Hour)),
+	})
+	require.NoError(t, err)
+	memPut(t, client, "documents/key1")
+	cfg := LoadConfig("../testdata/rule_with_expiration_non_current_days_0_days.json")
+
+	require.NoError(t, cmd.Execute(client, bucket, cfg))
+	require.Equal(t, 3, len(memVersions(t, client)))
+
+	require.NoError(t, cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.BypassGovernanceRetention = true }))
+	versions := memVersions(t, client)
+	require.Equal(t, 1, len(versions))
+	require.True(t, versions[0].DeleteMarker)
+}
+
+func TestMemManyKeysAcrossPages(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})
+	for i := 0; i < 1500; i++ {
+		memPut(t, client, fmt.Sprintf("documents/key%04d", i))
+	}
+	for i := 0; i < 5; i++ {
+		memPut(t, client, "documents/key0999")
+	}
+
+	cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+	require.NoError(t, cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Concurrency = 4 }))
+	// Every key is left with its delete marker and the version it hides
+	counts := map[string]int{}
+	for _, version := range memVersions(t, client) {
+		counts[version.Key]++
+	}
+	require.Equal(t, 1500, len(counts))
+	for key, count := range counts {
+		require.Equal(t, 2, count, key)
+	}
+}
+
+func TestMemLoadBucketConfig(t *testing.T) {
+	client := memBucket(t, &s3.CreateBucketInput{})
+	_, err := cmd.LoadBucketConfig(client, bucket)
+	require.Error(t, err)
+
+	_, err = client.PutBucketLifecycleConfiguration(context.TODO(), &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: &bucket,
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{{
+			ID:         aws.String("RULE001"),
+			Status:     types.ExpirationStatusEnabled,
+			Expiration: &types.LifecycleExpiration{Days: 1},
+		}}},
+	})
+	require.NoError(t, err)
+	cfg, err := cmd.LoadBucketConfig(client, bucket)
+	require.NoError(t, err)
+	require.Equal(t, 1, *cfg.Rules[0].Expiration.Days)
+}
+
+// batchClient records the size of the DeleteObjects calls, and fails the removal
+// of the keys selected by fail, letting the other keys of the batch through. The
+// result can be altered by rewrite, like other servers answer.
+type batchClient struct {
+	*s3mem.Client
+	fail    func(key string) bool
+	rewrite func(output *s3.DeleteObjectsOutput)
+	batches []int
+}
+
+func (c *batchClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.batches = append(c.batches, len(input.Delete.Objects))
+	var objects []types.ObjectIdentifier
+	var errs []types.Error
+	for _, object := range input.Delete.Objects {
+		if c.fail != nil && c.fail(aws.ToString(object.Key)) {
+			errs = append(errs, types.Error{Key: object.Key, VersionId: object.VersionId, Code: aws.String("InternalError"), Message: aws.String("try again")})
+			continue
+		}
+		objects = append(objects, object)
+	}
+	output, err := c.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{Bucket: input.Bucket, Delete: &types.Delete{Objects: objects}}, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	output.Errors = append(output.Errors, errs...)
+	if c.rewrite != nil {
+		c.rewrite(output)
+	}
+	return output, nil
+}
+
+// captureLog redirects the log output to a buffer for the duration of the test.
+func captureLog(t *testing.T) *strings.Builder {
+	var output strings.Builder
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &output
+}
+
+func TestMemDeleteObjectsBatches(t *testing.T) {
+	client := &batchClient{Client: memBucket(t, &s3.CreateBucketInput{})}
+	for i := 0; i < 2500; i++ {
+		memPut(t, client.Client, fmt.Sprintf("documents/key%04d", i))
+	}
+	captureLog(t)
+
+	cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+	require.NoError(t, cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Concurrency = 1 }))
+	require.Equal(t, []int{1000, 1000, 500}, client.batches)
+	require.Empty(t, memVersions(t, client.Client))
+}
+
+func TestMemDeleteObjectsErrors(t *testing.T) {
+	failed := func(key string) bool { return strings.HasSuffix(key, "00") }
+	client := &batchClient{Client: memBucket(t, &s3.CreateBucketInput{}), fail: failed}
+	for i := 0; i < 1200; i++ {
+		memPut(t, client.Client, fmt.Sprintf("documents/key%04d", i))
+	}
+	output := captureLog(t)
+
+	cfg := LoadConfig("../testdata/rule_with_expiration_newer_noncurrent_versions_0.json")
+	require.NoError(t, cmd.Execute(client, bucket, cfg, func(o *cmd.Options) { o.Concurrency = 1 }))
+	require.Equal(t, []int{1000, 200}, client.batches)
+
+	// Only the failed keys are left, the other keys of their batch are removed
+	versions := memVersions(t, client.Client)
+	require.Equal(t, 12, len(versions))
+	for _, version := range versions {
+		require.True(t, failed(version.Key), version.Key)
+		require.Contains(t, output.String(), fmt.Sprintf("key: %s, version null cannot be removed: InternalError: try again", version.Key))
+	}
+	require.Equal(t, 12, strings.Count(output.String(), "cannot be removed"))
+	require.Equal(t, 1188, strings.Count(output.String(), " removed\n"))
+}
+
+func TestMemDeleteObjectsResultVersionIds(t *testing.T) {
+	client := &batchClient{Client: memBucket(t, &s3.CreateBucketInput{ObjectLockEnabledForBucket: true})}
+	client.fail = func(key string) bool { return key == "documents/key2" }
+	// The expirations are answered with the version ID of the delete marker, or
+	// of the failed version, and with a key which was not requested
+	client.rewrite = func(output *s3.DeleteObjectsOutput) {
+		for i, deleted := range output.Deleted {
+			if deleted.VersionId == nil {
+				output.Deleted[i].VersionId = deleted.DeleteMarkerVersionId
+			}
+		}
+		for i := range output.Errors {
+			output.Errors[i].VersionId = aws.String("v0")
+		}
+		output.Deleted = append(output.Deleted, types.DeletedObject{Key: aws.String("documents/other"), VersionId: aws.String("v9")})
+	}
+	memPut(t, client.Client, "documents/key1")
+	memPut(t, client.Client, "documents/key2")
+	output := captureLog(t)
+
+	cfg := LoadConfig("../testdata/rule_with_expiration_0_days.json")
+	require.NoError(t, cmd.Execute(client, bucket, cfg))
+	require.Regexp(t, `key: documents/key1, version \S+ removed\n`, output.String())
+	require.Regexp(t, `key: documents/key2, version \S+ cannot be removed: InternalError: try again`, output.String())
+	require.Contains(t, output.String(), "[delete] key: documents/other, version v9 removed, not requested")
+}
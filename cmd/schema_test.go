@@ -0,0 +1,17 @@
+package cmd_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+)
+
+func TestSchemaCommand(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, 0, cmd.SchemaCommand([]string{}, stdout, stderr))
+	require.Contains(t, stdout.String(), `"$schema": "https://json-schema.org/draft/2020-12/schema"`)
+	require.Empty(t, stderr.String())
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+// SimulatedDay sums up what a rule removes on a day of a simulation.
+type SimulatedDay struct {
+	Date   time.Time
+	RuleID string
+	// Expired is the number of current objects expired by the rule.
+	Expired int
+	// Objects and Bytes count the versions removed for good, whose storage is
+	// freed.
+	Objects int
+	Bytes   int64
+}
+
+// Simulate replays the rules on the versions of an inventory every day from start,
+// for the given number of days, assuming nothing is written to the bucket
+// meanwhile. It returns what each rule removes, day by day, leaving out the days
+// a rule removes nothing.
+//
+// The rules filtering on tags never match since a listing holds no tags, and
+// Object Lock is not taken into account.
+func Simulate(inventory *Inventory, blc config.BucketLifecycleConfiguration, start time.Time, days int) []SimulatedDay {
+	rules := enabledRules(blc)
+	versioning := inventory.Versioning
+	histories := keyHistories(SortVersions(slices.Clone(inventory.Versions)))
+
+	var timeline []SimulatedDay
+	for day := 0; day < days; day++ {
+		today := start.AddDate(0, 0, day)
+		planner := &Planner{Rules: rules, Versioning: versioning, Clock: FixedClock(today)}
+
+		removed := map[string]*SimulatedDay{}
+		for i, history := range histories {
+			for _, action := range planner.PlanVersions(history) {
+				stats, ok := removed[action.RuleID]
+				if !ok {
+					stats = &SimulatedDay{Date: today, RuleID: action.RuleID}
+					removed[action.RuleID] = stats
+				}
+				history = simulateAction(history, action, versioning, today, stats)
+			}
+			histories[i] = history
+		}
+		histories = slices.DeleteFunc(histories, func(history []Version) bool { return len(history) == 0 })
+
+		for _, rule := range rules {
+			if stats, ok := removed[rule.ID]; ok && (stats.Expired > 0 || stats.Objects > 0) {
+				timeline = append(timeline, *stats)
+			}
+		}
+	}
+	return timeline
+}
+
+// keyHistories splits sorted versions into the version history of each key,
+// newest first.
+func keyHistories(versions []Version) [][]Version {
+	var histories [][]Version
+	for i, version := range versions {
+		if i == 0 || version.Key != versions[i-1].Key {
+			histories = append(histories, nil)
+		}
+		histories[len(histories)-1] = append(histories[len(histories)-1], version)
+	}
+	return histories
+}
+
+// simulateAction applies an action on the version history of a key the way the
+// bucket would, and records what it removes.
+func simulateAction(history []Version, action Action, versioning types.BucketVersioningStatus, today time.Time, stats *SimulatedDay) []Version {
+	idx := slices.IndexFunc(history, func(version Version) bool { return version.VersionId == action.VersionId })
+	if idx < 0 {
+		return history
+	}
+	remove := func(history []Version, idx int) []Version {
+		stats.Objects++
+		stats.Bytes += history[idx].Size
+		return slices.Delete(history, idx, idx+1)
+	}
+
+	switch action.Type {
+	case ActionDeleteVersion:
+		return remove(history, idx)
+	case ActionExpire:
+		stats.Expired++
+		switch versioning {
+		case types.BucketVersioningStatusEnabled:
+			history[idx].IsLatest = false
+			marker := Version{Key: action.Key, IsLatest: true, LastModified: today, VersionId: "simulated-" + today.Format(time.DateOnly), DeleteMarker: true}
+			return append([]Version{marker}, history...)
+		case types.BucketVersioningStatusSuspended:
+			// The delete marker replaces the null version
+			history[idx].IsLatest = false
+			if null := slices.IndexFunc(history, func(version Version) bool { return version.VersionId == NullVersionId }); null >= 0 {
+				history = remove(history, null)
+			}
+			marker := Version{Key: action.Key, IsLatest: true, LastModified: today, VersionId: NullVersionId, DeleteMarker: true}
+			return append([]Version{marker}, history...)
+		default:
+			return remove(history, idx)
+		}
+	}
+	return history
+}
+
+// SimulateCommand prints the timeline of the objects and bytes removed by the
+// rules of a configuration from a recorded listing of the bucket, and returns the
+// exit code.
+func SimulateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	configPath := flags.String("config", "", "Lifecycle configuration file (.json, .xml or .yaml)")
+	format := flags.String("format", "", "Configuration file format (json, xml or yaml), guessed from the file extension by default")
+	listingPath := flags.String("listing", "", "Recorded listing of the bucket, like --inventory (.json, .jsonl or .csv)")
+	days := flags.Int("days", 90, "Number of days to simulate")
+	now := flags.String("now", "", "First day of the simulation (2006-01-02 or RFC 3339), today by default")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: sos-client-bucket-lifecycle simulate --config CONFIG --listing LISTING [--days 90] [--now DATE]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 || *configPath == "" || *listingPath == "" || *days < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	start := time.Now().UTC().Truncate(24 * time.Hour)
+	if *now != "" {
+		date, err := ParseDate(*now)
+		if err != nil {
+			fmt.Fprintf(stderr, "Invalid --now date: %v\n", err)
+			return 2
+		}
+		start = date
+	}
+
+	cfg, err := LoadConfigFormat(*configPath, Format(*format))
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", *configPath, err)
+		return 1
+	}
+	for _, rule := range cfg.Rules {
+		if HasTagFilter(rule.Filter) {
+			log.Printf("[rule] %s filters on tags, which are not in the listing: it never matches", rule.ID)
+		}
+	}
+
+	inventory, err := ReadInventory(*listingPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	PrintTimeline(stdout, Simulate(inventory, *cfg, start, *days))
+	return 0
+}
+
+// PrintTimeline prints a simulation as a table, followed by the total of each
+// rule.
+func PrintTimeline(w io.Writer, timeline []SimulatedDay) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "DATE\tRULE\tEXPIRED\tOBJECTS\tBYTES")
+
+	var rules []string
+	totals := map[string]*SimulatedDay{}
+	for _, day := range timeline {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\n", day.Date.Format(time.DateOnly), day.RuleID, day.Expired, day.Objects, day.Bytes)
+
+		total, ok := totals[day.RuleID]
+		if !ok {
+			total = &SimulatedDay{RuleID: day.RuleID}
+			totals[day.RuleID] = total
+			rules = append(rules, day.RuleID)
+		}
+		total.Expired += day.Expired
+		total.Objects += day.Objects
+		total.Bytes += day.Bytes
+	}
+	for _, rule := range rules {
+		total := totals[rule]
+		fmt.Fprintf(table, "TOTAL\t%s\t%d\t%d\t%d\n", total.RuleID, total.Expired, total.Objects, total.Bytes)
+	}
+	table.Flush()
+}
@@ -0,0 +1,140 @@
+package cmd_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+)
+
+func date(day string) time.Time {
+	d, err := time.Parse(time.DateOnly, day)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestReadListing(t *testing.T) {
+	inventory, err := cmd.ReadInventory("../testdata/listing_versions.json")
+	require.NoError(t, err)
+	require.Equal(t, types.BucketVersioningStatusEnabled, inventory.Versioning)
+	require.Len(t, inventory.Versions, 4)
+	version := inventory.Versions[0]
+	require.Equal(t, "logs/a.log", version.Key)
+	require.Equal(t, "v2", version.VersionId)
+	require.True(t, version.IsLatest)
+	require.True(t, version.LastModified.Equal(date("2024-01-11")))
+	require.Equal(t, int64(100), version.Size)
+	require.True(t, inventory.Versions[3].DeleteMarker)
+
+	inventory, err = cmd.ReadInventory("../testdata/listing_objects.json")
+	require.NoError(t, err)
+	require.Equal(t, types.BucketVersioningStatus(""), inventory.Versioning)
+	require.Equal(t, []cmd.Version{
+		{Key: "logs/a.log", IsLatest: true, LastModified: date("2024-01-01"), VersionId: cmd.NullVersionId, Size: 100},
+		{Key: "logs/b.log", IsLatest: true, LastModified: date("2024-01-11"), VersionId: cmd.NullVersionId, Size: 200},
+	}, inventory.Versions)
+}
+
+func TestSimulateNullVersionsListing(t *testing.T) {
+	inventory, err := cmd.ReadListing(strings.NewReader(`{"Versions": [{"Key": "a", "VersionId": "null", "IsLatest": true, "LastModified": "2024-01-01T00:00:00Z", "Size": 100}]}`))
+	require.NoError(t, err)
+	require.Equal(t, types.BucketVersioningStatus(""), inventory.Versioning)
+
+	// The object is removed for good, like from the same inventory as JSONL
+	jsonl, err := cmd.ReadInventoryJSONL(strings.NewReader(`{"Key": "a", "VersionId": "null", "IsLatest": true, "LastModified": "2024-01-01T00:00:00Z", "Size": 100}`))
+	require.NoError(t, err)
+	cfg := LoadConfig("../testdata/rule_with_expiration_0_days.json")
+	timeline := cmd.Simulate(inventory, cfg, date("2024-01-11"), 1)
+	require.Equal(t, []cmd.SimulatedDay{{Date: date("2024-01-11"), RuleID: "ExampleRule", Expired: 1, Objects: 1, Bytes: 100}}, timeline)
+	require.Equal(t, timeline, cmd.Simulate(cmd.NewInventory(jsonl), cfg, date("2024-01-11"), 1))
+}
+
+func TestReadListingErrors(t *testing.T) {
+	_, err := cmd.ReadListing(strings.NewReader(`{"Versions": [{"Key": "a", "VersionId": "v1", "IsLatest": true, "LastModified": "2024-01-01T00:00:00Z"}], "DeleteMarkers": [{"Key": "a", "IsLatest": false}]}`))
+	require.EqualError(t, err, "DeleteMarkers[0]: LastModified is required")
+
+	_, err = cmd.ReadListing(strings.NewReader(`{"Contents": [{"LastModified": "2024-01-01T00:00:00Z"}]}`))
+	require.EqualError(t, err, "Contents[0]: Key is required")
+}
+
+func TestSimulate(t *testing.T) {
+	inventory, err := cmd.ReadInventory("../testdata/listing_versions.json")
+	require.NoError(t, err)
+	cfg := LoadConfig("../testdata/rules_with_10_days_and_noncurrent_20_days.json")
+
+	timeline := cmd.Simulate(inventory, cfg, date("2024-01-11"), 40)
+	require.Equal(t, []cmd.SimulatedDay{
+		{Date: date("2024-01-21"), RuleID: "Current", Expired: 1},
+		{Date: date("2024-01-31"), RuleID: "Noncurrent", Objects: 1, Bytes: 200},
+		{Date: date("2024-02-10"), RuleID: "Noncurrent", Objects: 2, Bytes: 400},
+	}, timeline)
+
+	// The inventory is left untouched
+	require.Len(t, inventory.Versions, 4)
+
+	// The inventories recorded as CSV or JSONL give the same timeline
+	for _, path := range []string{"../testdata/inventory.csv", "../testdata/inventory.jsonl"} {
+		inventory, err := cmd.ReadInventory(path)
+		require.NoError(t, err)
+		require.Equal(t, timeline, cmd.Simulate(inventory, cfg, date("2024-01-11"), 40), path)
+	}
+}
+
+func TestSimulateUnversioned(t *testing.T) {
+	inventory, err := cmd.ReadInventory("../testdata/listing_objects.json")
+	require.NoError(t, err)
+	cfg := LoadConfig("../testdata/rules_with_10_days_and_noncurrent_20_days.json")
+
+	timeline := cmd.Simulate(inventory, cfg, date("2024-01-11"), 15)
+	require.Equal(t, []cmd.SimulatedDay{
+		{Date: date("2024-01-11"), RuleID: "Current", Expired: 1, Objects: 1, Bytes: 100},
+		{Date: date("2024-01-21"), RuleID: "Current", Expired: 1, Objects: 1, Bytes: 200},
+	}, timeline)
+}
+
+func TestSimulateSuspended(t *testing.T) {
+	versions := []cmd.Version{
+		{Key: "key1", IsLatest: true, LastModified: date("2024-01-01"), VersionId: cmd.NullVersionId, Size: 100},
+		{Key: "key2", IsLatest: true, LastModified: date("2024-01-01"), VersionId: "v2", Size: 200},
+		{Key: "key2", LastModified: date("2023-12-01"), VersionId: "v1", Size: 300},
+	}
+	cfg := LoadConfig("../testdata/rules_with_10_days_and_noncurrent_20_days.json")
+
+	// The null version is replaced by the delete marker, the other versions become
+	// noncurrent
+	inventory := &cmd.Inventory{Versions: versions, Versioning: types.BucketVersioningStatusSuspended}
+	timeline := cmd.Simulate(inventory, cfg, date("2024-01-11"), 25)
+	require.Equal(t, []cmd.SimulatedDay{
+		{Date: date("2024-01-11"), RuleID: "Current", Expired: 2, Objects: 1, Bytes: 100},
+		{Date: date("2024-01-21"), RuleID: "Noncurrent", Objects: 1, Bytes: 300},
+		{Date: date("2024-01-31"), RuleID: "Noncurrent", Objects: 1, Bytes: 200},
+	}, timeline)
+}
+
+func TestSimulateCommand(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := cmd.SimulateCommand([]string{
+		"--config", "../testdata/rules_with_10_days_and_noncurrent_20_days.json",
+		"--listing", "../testdata/listing_versions.json",
+		"--now", "2024-01-11",
+		"--days", "40",
+	}, stdout, stderr)
+	require.Equal(t, 0, code, stderr.String())
+	require.Equal(t, ""+
+		"DATE        RULE        EXPIRED  OBJECTS  BYTES\n"+
+		"2024-01-21  Current     1        0        0\n"+
+		"2024-01-31  Noncurrent  0        1        200\n"+
+		"2024-02-10  Noncurrent  0        2        400\n"+
+		"TOTAL       Current     1        0        0\n"+
+		"TOTAL       Noncurrent  0        3        600\n", stdout.String())
+
+	code = cmd.SimulateCommand([]string{"--config", "../testdata/rules_with_10_days_and_noncurrent_20_days.json"}, stdout, stderr)
+	require.Equal(t, 2, code)
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// targetClients creates the clients of the zones targeted by transitions once.
+type targetClients struct {
+	source    S3Client
+	newClient func(zone string) (S3Client, error)
+
+	mu      sync.Mutex
+	clients map[string]S3Client
+}
+
+func (t *targetClients) get(zone string) (S3Client, error) {
+	if zone == "" || t.newClient == nil {
+		return t.source, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if client, ok := t.clients[zone]; ok {
+		return client, nil
+	}
+	client, err := t.newClient(zone)
+	if err != nil {
+		return nil, err
+	}
+	t.clients[zone] = client
+	return client, nil
+}
+
+// The copies are marked with the version and the ETag of their source, to find
+// out whether a version was copied already.
+const (
+	sourceVersionIdMetadata = "source-version-id"
+	sourceETagMetadata      = "source-etag"
+)
+
+// transition copies a version to the target of the action, keeping its metadata
+// and tags, and verifies the copy. The copy is skipped, and false returned, when
+// the target already holds a copy of the version.
+func transition(ctx context.Context, source S3Client, bucket *string, target S3Client, action Action) (bool, error) {
+	copied, err := target.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &action.TargetBucket, Key: &action.TargetKey})
+	if err != nil {
+		copied = nil
+	}
+	if copied != nil && action.VersionId != NullVersionId && copied.Metadata[sourceVersionIdMetadata] == action.VersionId {
+		return false, nil
+	}
+
+	head, err := source.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: &action.Key, VersionId: &action.VersionId})
+	if err != nil {
+		return false, err
+	}
+	// The null version is replaced when the object is written again
+	if copied != nil && action.VersionId == NullVersionId && copied.Metadata[sourceVersionIdMetadata] == NullVersionId &&
+		copied.Metadata[sourceETagMetadata] == aws.ToString(head.ETag) {
+		return false, nil
+	}
+
+	tagging, err := source.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: bucket, Key: &action.Key, VersionId: &action.VersionId})
+	if err != nil {
+		return false, err
+	}
+	tags := url.Values{}
+	for _, tag := range tagging.TagSet {
+		tags.Add(aws.ToString(tag.Key), aws.ToString(tag.Value))
+	}
+
+	object, err := source.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: &action.Key, VersionId: &action.VersionId})
+	if err != nil {
+		return false, err
+	}
+	defer object.Body.Close()
+
+	metadata := maps.Clone(object.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[sourceVersionIdMetadata] = action.VersionId
+	metadata[sourceETagMetadata] = aws.ToString(head.ETag)
+
+	// The body is streamed from the source, it cannot be read twice to sign it
+	_, err = target.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:             &action.TargetBucket,
+		Key:                &action.TargetKey,
+		Body:               object.Body,
+		ContentLength:      object.ContentLength,
+		CacheControl:       object.CacheControl,
+		ContentDisposition: object.ContentDisposition,
+		ContentEncoding:    object.ContentEncoding,
+		ContentLanguage:    object.ContentLanguage,
+		ContentType:        object.ContentType,
+		Expires:            object.Expires,
+		Metadata:           metadata,
+		Tagging:            aws.String(tags.Encode()),
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	if err != nil {
+		return false, err
+	}
+
+	return true, verifyCopy(ctx, target, action, head)
+}
+
+// verifyCopy checks that the target holds an object of the same size and ETag as
+// the source. Only the size of an object uploaded in several parts is compared,
+// its copy being uploaded in one part.
+func verifyCopy(ctx context.Context, target S3Client, action Action, source *s3.HeadObjectOutput) error {
+	head, err := target.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &action.TargetBucket, Key: &action.TargetKey})
+	if err != nil {
+		return err
+	}
+	if head.ContentLength != source.ContentLength {
+		return fmt.Errorf("size mismatch: %d bytes copied out of %d", head.ContentLength, source.ContentLength)
+	}
+	etag := aws.ToString(source.ETag)
+	if !strings.Contains(etag, "-") && aws.ToString(head.ETag) != etag {
+		return fmt.Errorf("ETag mismatch: %s copied for %s", aws.ToString(head.ETag), etag)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ValidateCommand checks configuration files without connecting to SOS, and
+// returns the exit code: 1 when a configuration is invalid, 2 on a usage error.
+func ValidateCommand(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "", "Configuration file format (json, xml or yaml), guessed from the file extension by default")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: sos-client-bucket-lifecycle validate [--format json|xml|yaml] CONFIG...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	code := 0
+	for _, configPath := range flags.Args() {
+		errs := ValidateConfig(configPath, Format(*format))
+		for _, err := range errs {
+			fmt.Fprintf(stderr, "%s: %s\n", configPath, err)
+		}
+		if len(errs) > 0 {
+			code = 1
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: valid\n", configPath)
+	}
+	return code
+}
+
+// ValidateConfig loads a configuration file and runs the extra checks of
+// config.BucketLifecycleConfiguration.Check on it.
+func ValidateConfig(configPath string, format Format) []error {
+	blc, err := LoadConfigFormat(configPath, format)
+	if err != nil {
+		var validationErrs validator.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			errs := make([]error, 0, len(validationErrs))
+			for _, fieldErr := range validationErrs {
+				errs = append(errs, describeFieldError(fieldErr))
+			}
+			return errs
+		}
+		return []error{err}
+	}
+	return blc.Check()
+}
+
+// describeFieldError rephrases the validator errors, which name the failed tag
+// rather than the expected value.
+func describeFieldError(err validator.FieldError) error {
+	_, field, _ := strings.Cut(err.Namespace(), ".")
+	var expected string
+	switch err.Tag() {
+	case "required":
+		expected = "is required"
+	case "oneof":
+		expected = fmt.Sprintf("must be one of %s", strings.Join(strings.Fields(err.Param()), ", "))
+	case "max":
+		expected = fmt.Sprintf("must be at most %s", err.Param())
+	case "min":
+		expected = fmt.Sprintf("must be at least %s", err.Param())
+	case "excluded_with":
+		expected = fmt.Sprintf("cannot be set along with %s", err.Param())
+	case "ltfield":
+		expected = fmt.Sprintf("must be less than %s", err.Param())
+	case "gtfield":
+		expected = fmt.Sprintf("must be greater than %s", err.Param())
+	default:
+		expected = fmt.Sprintf("does not pass the %s check", err.Tag())
+	}
+	return fmt.Errorf("%s %s", field, expected)
+}
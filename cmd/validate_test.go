@@ -0,0 +1,41 @@
+package cmd_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/cmd"
+)
+
+func TestValidateCommand(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := cmd.ValidateCommand([]string{"../testdata/rule_with_expiration_and_filter.json", "../testdata/rule_with_expiration_and_tags.yaml"}, stdout, stderr)
+	require.Equal(t, 0, code)
+	require.Equal(t, "../testdata/rule_with_expiration_and_filter.json: valid\n../testdata/rule_with_expiration_and_tags.yaml: valid\n", stdout.String())
+	require.Empty(t, stderr.String())
+}
+
+func TestValidateCommandErrors(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := cmd.ValidateCommand([]string{"../testdata/invalid_rules_with_contradictions.json"}, stdout, stderr)
+	require.Equal(t, 1, code)
+	require.Empty(t, stdout.String())
+	require.Equal(t, 3, bytes.Count(stderr.Bytes(), []byte("\n")))
+	require.Contains(t, stderr.String(), "rule Expire: duplicate rule ID")
+	// The rule matching no object contradicts none
+	require.NotContains(t, stderr.String(), "never happens")
+}
+
+func TestValidateCommandFieldErrors(t *testing.T) {
+	errs := cmd.ValidateConfig("../testdata/invalid_rule_with_storage_class_transition.xml", "")
+	require.Equal(t, 1, len(errs))
+	require.EqualError(t, errs[0], "Rules[0].Transitions[0].Bucket is required")
+}
+
+func TestValidateCommandUsage(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	require.Equal(t, 2, cmd.ValidateCommand([]string{}, stdout, stderr))
+	require.Contains(t, stderr.String(), "Usage")
+}
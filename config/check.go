@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Check reports the mistakes which do not prevent the configuration from being
+// applied, but make some of its rules behave differently than intended. Unlike
+// Validate, it returns every problem found.
+func (blc *BucketLifecycleConfiguration) Check() []error {
+	errs := []error{}
+	ids := map[string]bool{}
+	for _, rule := range blc.Rules {
+		if ids[rule.ID] {
+			errs = append(errs, fmt.Errorf("rule %s: duplicate rule ID", rule.ID))
+		}
+		ids[rule.ID] = true
+
+		if filter := rule.Filter; filter != nil && filter.ObjectSizeGreaterThan != nil && filter.ObjectSizeLessThan != nil &&
+			*filter.ObjectSizeGreaterThan >= *filter.ObjectSizeLessThan {
+			errs = append(errs, fmt.Errorf("rule %s: ObjectSizeGreaterThan (%d) is not less than ObjectSizeLessThan (%d), no object can match",
+				rule.ID, *filter.ObjectSizeGreaterThan, *filter.ObjectSizeLessThan))
+		}
+
+		if expiration := rule.NoncurrentVersionExpiration; expiration != nil && expiration.NoncurrentDays == nil && expiration.NewerNoncurrentVersions == nil {
+			errs = append(errs, fmt.Errorf("rule %s: NoncurrentVersionExpiration has neither NoncurrentDays nor NewerNoncurrentVersions", rule.ID))
+		}
+	}
+
+	// A rule conflicts with itself too, when it expires the objects before
+	// transitioning them. A rule whose filter matches no object conflicts with
+	// none.
+	for _, expiring := range blc.Rules {
+		for _, transitioning := range blc.Rules {
+			if expiring.Status != "Enabled" || transitioning.Status != "Enabled" || !overlap(expiring, transitioning) {
+				continue
+			}
+			errs = append(errs, contradictions(expiring, transitioning)...)
+		}
+	}
+	return errs
+}
+
+// contradictions reports the transitions of a rule which never happen because
+// another rule targeting the same objects expires them first.
+func contradictions(expiring, transitioning Rule) []error {
+	errs := []error{}
+	if expiration := expiring.Expiration; expiration != nil && expiration.Days != nil {
+		for _, transition := range transitioning.Transitions {
+			// On the same day, the objects are transitioned before being expired
+			if transition.Days != nil && *transition.Days > *expiration.Days {
+				errs = append(errs, fmt.Errorf("rule %s: the transition to %s after %d days never happens, rule %s expires the objects after %d days",
+					transitioning.ID, transition.Bucket, *transition.Days, expiring.ID, *expiration.Days))
+			}
+		}
+	}
+	if expiration := expiring.NoncurrentVersionExpiration; expiration != nil && expiration.NoncurrentDays != nil {
+		for _, transition := range transitioning.NoncurrentVersionTransitions {
+			// On the same day, the permanent deletion wins over the transition
+			if transition.NoncurrentDays != nil && *transition.NoncurrentDays >= *expiration.NoncurrentDays {
+				errs = append(errs, fmt.Errorf("rule %s: the noncurrent version transition to %s after %d days never happens, rule %s expires the noncurrent versions after %d days",
+					transitioning.ID, transition.Bucket, *transition.NoncurrentDays, expiring.ID, *expiration.NoncurrentDays))
+			}
+		}
+	}
+	return errs
+}
+
+// overlap reports whether some objects can be targeted by both rules.
+func overlap(a, b Rule) bool {
+	if !prefixesOverlap(a, b) {
+		return false
+	}
+	greaterThanA, lessThanA := a.sizeRange()
+	greaterThanB, lessThanB := b.sizeRange()
+	if max(greaterThanA, greaterThanB) >= min(lessThanA, lessThanB) {
+		return false
+	}
+	tagsA, okA := a.tags()
+	tagsB, okB := b.tags()
+	if !okA || !okB {
+		return false
+	}
+	for key, value := range tagsA {
+		if other, ok := tagsB[key]; ok && other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixesOverlap reports whether some keys can be targeted by both rules,
+// other filters aside.
+func prefixesOverlap(a, b Rule) bool {
+	prefixA, prefixB := a.prefix(), b.prefix()
+	return strings.HasPrefix(prefixA, prefixB) || strings.HasPrefix(prefixB, prefixA)
+}
+
+// sizeRange returns the bounds the size of the objects targeted by the rule is
+// strictly within, the bounds of the And block combined with the top-level ones.
+func (r Rule) sizeRange() (greaterThan, lessThan int64) {
+	greaterThan, lessThan = -1, math.MaxInt64
+	if r.Filter == nil {
+		return greaterThan, lessThan
+	}
+	bounds := [][2]*int64{{r.Filter.ObjectSizeGreaterThan, r.Filter.ObjectSizeLessThan}}
+	if r.Filter.And != nil {
+		bounds = append(bounds, [2]*int64{r.Filter.And.ObjectSizeGreaterThan, r.Filter.And.ObjectSizeLessThan})
+	}
+	for _, bound := range bounds {
+		if bound[0] != nil {
+			greaterThan = max(greaterThan, *bound[0])
+		}
+		if bound[1] != nil {
+			lessThan = min(lessThan, *bound[1])
+		}
+	}
+	return greaterThan, lessThan
+}
+
+// tags returns the tags the objects targeted by the rule hold, and false when
+// the rule requires two values of the same tag.
+func (r Rule) tags() (map[string]string, bool) {
+	tags := map[string]string{}
+	if r.Filter == nil {
+		return tags, true
+	}
+	expected := []Tag{}
+	if r.Filter.Tag != nil {
+		expected = append(expected, *r.Filter.Tag)
+	}
+	if r.Filter.And != nil {
+		expected = append(expected, r.Filter.And.Tags...)
+	}
+	for _, tag := range expected {
+		if value, ok := tags[tag.Key]; ok && value != tag.Value {
+			return nil, false
+		}
+		tags[tag.Key] = tag.Value
+	}
+	return tags, true
+}
+
+// prefix returns the key prefix targeted by the rule, empty when it targets
+// every key.
+func (r Rule) prefix() string {
+	if r.Filter == nil {
+		return ""
+	}
+	if r.Filter.Prefix != nil {
+		return *r.Filter.Prefix
+	}
+	if r.Filter.And != nil && r.Filter.And.Prefix != nil {
+		return *r.Filter.And.Prefix
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+func TestCheckDuplicateIDs(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{
+		{ID: "RULE001", Status: "Enabled", Expiration: &config.Expiration{Days: aws.Int(1)}},
+		{ID: "RULE001", Status: "Enabled", Expiration: &config.Expiration{Days: aws.Int(2)}},
+	}}
+	errs := blc.Check()
+	require.Equal(t, 1, len(errs))
+	require.ErrorContains(t, errs[0], "duplicate rule ID")
+}
+
+func TestCheckSizeFilter(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{{
+		ID:         "RULE001",
+		Status:     "Enabled",
+		Filter:     &config.Filter{ObjectSizeGreaterThan: aws.Int64(10), ObjectSizeLessThan: aws.Int64(5)},
+		Expiration: &config.Expiration{Days: aws.Int(1)},
+	}}}
+	errs := blc.Check()
+	require.Equal(t, 1, len(errs))
+	require.ErrorContains(t, errs[0], "no object can match")
+}
+
+func TestCheckEmptyNoncurrentVersionExpiration(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{{
+		ID:                          "RULE001",
+		Status:                      "Enabled",
+		NoncurrentVersionExpiration: &config.NoncurrentVersionExpiration{},
+	}}}
+	errs := blc.Check()
+	require.Equal(t, 1, len(errs))
+	require.ErrorContains(t, errs[0], "neither NoncurrentDays nor NewerNoncurrentVersions")
+}
+
+func TestCheckTransitionAfterExpiration(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{
+		{ID: "Expire", Status: "Enabled", Filter: &config.Filter{Prefix: aws.String("logs/")}, Expiration: &config.Expiration{Days: aws.Int(30)}},
+		{ID: "Archive", Status: "Enabled", Filter: &config.Filter{Prefix: aws.String("logs/archive/")}, Transitions: []config.Transition{{Days: aws.Int(90), Bucket: "archive"}}},
+		{ID: "Other", Status: "Enabled", Filter: &config.Filter{Prefix: aws.String("documents/")}, Transitions: []config.Transition{{Days: aws.Int(90), Bucket: "archive"}}},
+		{ID: "Disabled", Status: "Disabled", Transitions: []config.Transition{{Days: aws.Int(90), Bucket: "archive"}}},
+		{ID: "SameDay", Status: "Enabled", Transitions: []config.Transition{{Days: aws.Int(30), Bucket: "archive"}}},
+	}}
+	errs := blc.Check()
+	require.Equal(t, 1, len(errs))
+	require.ErrorContains(t, errs[0], "rule Archive: the transition to archive after 90 days never happens, rule Expire")
+}
+
+func TestCheckNoncurrentVersionTransitionSameDay(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{{
+		ID:                           "RULE001",
+		Status:                       "Enabled",
+		NoncurrentVersionExpiration:  &config.NoncurrentVersionExpiration{NoncurrentDays: aws.Int(30)},
+		NoncurrentVersionTransitions: []config.NoncurrentVersionTransition{{NoncurrentDays: aws.Int(30), Bucket: "archive"}},
+	}}}
+	require.Equal(t, 1, len(blc.Check()))
+}
+
+func TestCheckTransitionDisjointFilters(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{
+		{ID: "Small", Status: "Enabled", Filter: &config.Filter{ObjectSizeLessThan: aws.Int64(1024)}, Expiration: &config.Expiration{Days: aws.Int(30)}},
+		{ID: "Large", Status: "Enabled", Filter: &config.Filter{And: &config.AndFilter{ObjectSizeGreaterThan: aws.Int64(1024)}}, Transitions: []config.Transition{{Days: aws.Int(90), Bucket: "archive"}}},
+	}}
+	require.Empty(t, blc.Check())
+
+	blc = config.BucketLifecycleConfiguration{Rules: []config.Rule{
+		{ID: "Logs", Status: "Enabled", Filter: &config.Filter{Tag: &config.Tag{Key: "type", Value: "logs"}}, Expiration: &config.Expiration{Days: aws.Int(30)}},
+		{ID: "Documents", Status: "Enabled", Filter: &config.Filter{Tag: &config.Tag{Key: "type", Value: "documents"}}, Transitions: []config.Transition{{Days: aws.Int(90), Bucket: "archive"}}},
+	}}
+	require.Empty(t, blc.Check())
+}
+
+func TestCheckTransitionFilterMatchingNothing(t *testing.T) {
+	blc := config.BucketLifecycleConfiguration{Rules: []config.Rule{
+		{ID: "Expire", Status: "Enabled", Filter: &config.Filter{And: &config.AndFilter{Tags: []config.Tag{{Key: "type", Value: "logs"}, {Key: "type", Value: "documents"}}}}, Expiration: &config.Expiration{Days: aws.Int(30)}},
+		{ID: "Archive", Status: "Enabled", Transitions: []config.Transition{{Days: aws.Int(90), Bucket: "archive"}}},
+	}}
+	require.Empty(t, blc.Check())
+}
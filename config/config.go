@@ -1,54 +1,129 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// BucketLifecycleConfiguration is read from JSON or YAML, with the same field
+// names, or from the S3 LifecycleConfiguration XML document where the lists are
+// repeated elements (Rule, Tag, Transition and NoncurrentVersionTransition).
 type BucketLifecycleConfiguration struct {
-	Rules []Rule `json:"Rules" validate:"required,dive"`
+	Rules []Rule `json:"Rules" yaml:"Rules" xml:"Rule" validate:"required,dive"`
 }
 
+// Rule is an S3 lifecycle rule. Its ID is up to 255 characters long, of any kind
+// like on S3, so that the rules of a bucket can be mirrored.
 type Rule struct {
-	ID                             string                          `json:"ID" validate:"required,alphanum,max=255"`
-	Status                         string                          `json:"Status" validate:"required,oneof=Enabled Disabled"`
-	Filter                         *Filter                         `json:"Filter,omitempty"`
-	Expiration                     *Expiration                     `json:"Expiration,omitempty"`
-	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `json:"NoncurrentVersionExpiration,omitempty"`
-	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `json:"AbortIncompleteMultipartUpload,omitempty"`
+	ID                             string                          `json:"ID" yaml:"ID" validate:"required,max=255"`
+	Status                         string                          `json:"Status" yaml:"Status" validate:"required,oneof=Enabled Disabled"`
+	Filter                         *Filter                         `json:"Filter,omitempty" yaml:"Filter,omitempty"`
+	Expiration                     *Expiration                     `json:"Expiration,omitempty" yaml:"Expiration,omitempty"`
+	NoncurrentVersionExpiration    *NoncurrentVersionExpiration    `json:"NoncurrentVersionExpiration,omitempty" yaml:"NoncurrentVersionExpiration,omitempty"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `json:"AbortIncompleteMultipartUpload,omitempty" yaml:"AbortIncompleteMultipartUpload,omitempty"`
+	Transitions                    []Transition                    `json:"Transitions,omitempty" yaml:"Transitions,omitempty" xml:"Transition" validate:"omitempty,dive"`
+	NoncurrentVersionTransitions   []NoncurrentVersionTransition   `json:"NoncurrentVersionTransitions,omitempty" yaml:"NoncurrentVersionTransitions,omitempty" xml:"NoncurrentVersionTransition" validate:"omitempty,dive"`
 }
 
 type Filter struct {
-	Prefix                *string    `json:"Prefix,omitempty" validate:"omitempty,dirpath|filepath"`
-	ObjectSizeGreaterThan *int64     `json:"ObjectSizeGreaterThan,omitempty" validate:"omitempty,number"`
-	ObjectSizeLessThan    *int64     `json:"ObjectSizeLessThan,omitempty" validate:"omitempty,number"`
-	And                   *AndFilter `json:"And,omitempty"`
+	Prefix                *string    `json:"Prefix,omitempty" yaml:"Prefix,omitempty" validate:"omitempty,dirpath|filepath"`
+	Tag                   *Tag       `json:"Tag,omitempty" yaml:"Tag,omitempty"`
+	ObjectSizeGreaterThan *int64     `json:"ObjectSizeGreaterThan,omitempty" yaml:"ObjectSizeGreaterThan,omitempty" validate:"omitempty,number"`
+	ObjectSizeLessThan    *int64     `json:"ObjectSizeLessThan,omitempty" yaml:"ObjectSizeLessThan,omitempty" validate:"omitempty,number"`
+	And                   *AndFilter `json:"And,omitempty" yaml:"And,omitempty"`
 }
 
 type AndFilter struct {
-	Prefix                *string `json:"Prefix,omitempty" validate:"omitempty,dirpath|filepath"`
-	ObjectSizeGreaterThan *int64  `json:"ObjectSizeGreaterThan,omitempty" validate:"omitempty,number,ltfield=ObjectSizeLessThan"`
-	ObjectSizeLessThan    *int64  `json:"ObjectSizeLessThan,omitempty" validate:"omitempty,number,gtfield=ObjectSizeGreaterThan"`
+	Prefix                *string `json:"Prefix,omitempty" yaml:"Prefix,omitempty" validate:"omitempty,dirpath|filepath"`
+	Tags                  []Tag   `json:"Tags,omitempty" yaml:"Tags,omitempty" xml:"Tag" validate:"omitempty,dive"`
+	ObjectSizeGreaterThan *int64  `json:"ObjectSizeGreaterThan,omitempty" yaml:"ObjectSizeGreaterThan,omitempty" validate:"omitempty,number,ltfield=ObjectSizeLessThan"`
+	ObjectSizeLessThan    *int64  `json:"ObjectSizeLessThan,omitempty" yaml:"ObjectSizeLessThan,omitempty" validate:"omitempty,number,gtfield=ObjectSizeGreaterThan"`
+}
+
+type Tag struct {
+	Key   string `json:"Key" yaml:"Key" validate:"required,max=128"`
+	Value string `json:"Value" yaml:"Value" validate:"max=256"`
 }
 
 type Expiration struct {
-	Days                      *int `json:"Days,omitempty" validate:"omitempty,number,min=0"`
-	ExpiredObjectDeleteMarker bool `json:"ExpiredObjectDeleteMarker,omitempty" validate:"omitempty,boolean"`
+	Date                      *Date `json:"Date,omitempty" yaml:"Date,omitempty" validate:"omitempty,excluded_with=Days"`
+	Days                      *int  `json:"Days,omitempty" yaml:"Days,omitempty" validate:"omitempty,number,min=0"`
+	ExpiredObjectDeleteMarker bool  `json:"ExpiredObjectDeleteMarker,omitempty" yaml:"ExpiredObjectDeleteMarker,omitempty" validate:"omitempty,boolean"`
+}
+
+// Date is the day the objects expire, given on its own as 2006-01-02 or in
+// RFC 3339.
+type Date struct {
+	time.Time
+}
+
+func (d *Date) UnmarshalText(text []byte) error {
+	date, err := time.Parse(time.DateOnly, string(text))
+	if err != nil {
+		date, err = time.Parse(time.RFC3339, string(text))
+	}
+	if err != nil {
+		return fmt.Errorf("%q is neither 2006-01-02 nor RFC 3339", text)
+	}
+	d.Time = date
+	return nil
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(text))
+}
+
+func (d *Date) UnmarshalYAML(value *yaml.Node) error {
+	return d.UnmarshalText([]byte(value.Value))
 }
 
 type NoncurrentVersionExpiration struct {
-	NoncurrentDays          *int `json:"NoncurrentDays,omitempty" validate:"omitempty,number,min=0"`
-	NewerNoncurrentVersions *int `json:"NewerNoncurrentVersions,omitempty" validate:"omitempty,number,min=0"`
+	NoncurrentDays          *int `json:"NoncurrentDays,omitempty" yaml:"NoncurrentDays,omitempty" validate:"omitempty,number,min=0"`
+	NewerNoncurrentVersions *int `json:"NewerNoncurrentVersions,omitempty" yaml:"NewerNoncurrentVersions,omitempty" validate:"omitempty,number,min=0"`
+}
+
+// Transition copies the current version of the objects to another bucket,
+// possibly in another zone, in place of the S3 storage classes.
+type Transition struct {
+	Days         *int   `json:"Days,omitempty" yaml:"Days,omitempty" validate:"required,number,min=0"`
+	Bucket       string `json:"Bucket" yaml:"Bucket" validate:"required"`
+	Zone         string `json:"Zone,omitempty" yaml:"Zone,omitempty"`
+	DeleteSource bool   `json:"DeleteSource,omitempty" yaml:"DeleteSource,omitempty" validate:"omitempty,boolean"`
+}
+
+// NoncurrentVersionTransition moves the noncurrent versions to another bucket,
+// possibly in another zone, once they have been noncurrent for NoncurrentDays.
+type NoncurrentVersionTransition struct {
+	NoncurrentDays *int   `json:"NoncurrentDays,omitempty" yaml:"NoncurrentDays,omitempty" validate:"required,number,min=0"`
+	Bucket         string `json:"Bucket" yaml:"Bucket" validate:"required"`
+	Zone           string `json:"Zone,omitempty" yaml:"Zone,omitempty"`
 }
 
 type AbortIncompleteMultipartUpload struct {
-	DaysAfterInitiation *int `json:"DaysAfterInitiation,omitempty" validate:"number,min=0"`
+	DaysAfterInitiation *int `json:"DaysAfterInitiation,omitempty" yaml:"DaysAfterInitiation,omitempty" validate:"required,number,min=0"`
 }
 
 func (blc *BucketLifecycleConfiguration) Validate() error {
 	for _, rule := range blc.Rules {
-		if rule.AbortIncompleteMultipartUpload == nil && rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil {
+		if rule.AbortIncompleteMultipartUpload == nil && rule.Expiration == nil && rule.NoncurrentVersionExpiration == nil && len(rule.Transitions) == 0 && len(rule.NoncurrentVersionTransitions) == 0 {
 			return fmt.Errorf("at least one action needs to be specified in a rule")
 		}
+		if expiration := rule.Expiration; expiration != nil && expiration.Date == nil && expiration.Days == nil && !expiration.ExpiredObjectDeleteMarker {
+			return fmt.Errorf("rule %s: Expiration has neither Date, Days nor ExpiredObjectDeleteMarker", rule.ID)
+		}
+		if rule.Expiration != nil && rule.Expiration.Date != nil {
+			date := rule.Expiration.Date.Time
+			if !date.Equal(date.UTC().Truncate(24 * time.Hour)) {
+				return fmt.Errorf("rule %s: expiration date %s is not at midnight UTC", rule.ID, date.Format(time.RFC3339))
+			}
+		}
 
 	}
 	return nil
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SchemaID is the JSON Schema dialect of the schema returned by Schema.
+const SchemaID = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema returns the JSON Schema of BucketLifecycleConfiguration, generated from
+// the json and validate tags of the structs so that it cannot drift from them.
+// The checks of Validate and the validate tags which compare fields (ltfield,
+// gtfield) are not part of it.
+func Schema() map[string]any {
+	g := &schemaGenerator{defs: map[string]any{}}
+	schema := g.schema(reflect.TypeOf(BucketLifecycleConfiguration{}))
+	schema["$schema"] = SchemaID
+	schema["title"] = "BucketLifecycleConfiguration"
+	schema["$defs"] = g.defs
+	return schema
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case IsDate(t):
+		// A date on its own is accepted as well as RFC 3339, see Date.UnmarshalText
+		return map[string]any{"type": "string", "anyOf": []any{map[string]any{"format": "date"}, map[string]any{"format": "date-time"}}}
+	case t.Kind() == reflect.Struct:
+		return g.object(t)
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": g.ref(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	}
+	return map[string]any{}
+}
+
+// ref defines the structs once, under $defs, and refers to them.
+func (g *schemaGenerator) ref(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || IsDate(t) {
+		return g.schema(t)
+	}
+	if _, ok := g.defs[t.Name()]; !ok {
+		// Reserve the name first in case the struct refers to itself
+		g.defs[t.Name()] = nil
+		g.defs[t.Name()] = g.object(t)
+	}
+	return map[string]any{"$ref": "#/$defs/" + t.Name()}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	exclusions := []any{}
+	fields := Fields(t)
+	names := map[string]string{}
+	for _, field := range fields {
+		names[field.Name] = field.JSONName
+	}
+
+	for _, field := range fields {
+		name := field.JSONName
+		property := g.ref(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			tag, param, _ := strings.Cut(rule, "=")
+			switch tag {
+			case "required":
+				required = append(required, name)
+				// The validator rejects empty strings too, an empty list is accepted
+				if field.Type.Kind() == reflect.String {
+					property["minLength"] = 1
+				}
+			case "oneof":
+				enum := []any{}
+				for _, value := range strings.Fields(param) {
+					enum = append(enum, value)
+				}
+				property["enum"] = enum
+			case "min", "max":
+				if n, err := strconv.Atoi(param); err == nil {
+					property[boundKeyword(tag, property["type"])] = n
+				}
+			case "excluded_with":
+				if other, ok := names[param]; ok {
+					exclusions = append(exclusions, map[string]any{"not": map[string]any{"required": []string{name, other}}})
+				}
+			}
+		}
+		properties[name] = property
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if len(exclusions) > 0 {
+		schema["allOf"] = exclusions
+	}
+	return schema
+}
+
+// boundKeyword returns the keyword of a min or max validate tag, which bounds
+// the length of strings and arrays, and the value of numbers.
+func boundKeyword(tag string, schemaType any) string {
+	prefix := "min"
+	if tag == "max" {
+		prefix = "max"
+	}
+	switch schemaType {
+	case "string":
+		return prefix + "Length"
+	case "array":
+		return prefix + "Items"
+	}
+	if prefix == "min" {
+		return "minimum"
+	}
+	return "maximum"
+}
+
+var dateType = reflect.TypeOf(Date{})
+
+// IsDate reports whether the type is Date, which is a string in JSON and YAML
+// although it is a struct.
+func IsDate(t reflect.Type) bool {
+	return t == dateType
+}
+
+// Field is a field of a configuration struct.
+type Field struct {
+	reflect.StructField
+	// JSONName is the name of the field in JSON and YAML.
+	JSONName string
+}
+
+// Fields returns the fields of a configuration struct in JSON and YAML, named
+// after their json tag, for the decoder and the schema to agree on them.
+func Fields(t reflect.Type) []Field {
+	fields := []Field{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, Field{StructField: field, JSONName: name})
+	}
+	return fields
+}
@@ -0,0 +1,78 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/config"
+)
+
+func definition(t *testing.T, name string) map[string]any {
+	defs := config.Schema()["$defs"].(map[string]any)
+	require.Contains(t, defs, name)
+	return defs[name].(map[string]any)
+}
+
+func TestSchemaRoot(t *testing.T) {
+	schema := config.Schema()
+	require.Equal(t, config.SchemaID, schema["$schema"])
+	require.Equal(t, []string{"Rules"}, schema["required"])
+	rules := schema["properties"].(map[string]any)["Rules"].(map[string]any)
+	require.Equal(t, "array", rules["type"])
+	require.Equal(t, map[string]any{"$ref": "#/$defs/Rule"}, rules["items"])
+}
+
+func TestSchemaRule(t *testing.T) {
+	rule := definition(t, "Rule")
+	require.Equal(t, []string{"ID", "Status"}, rule["required"])
+	require.Equal(t, false, rule["additionalProperties"])
+
+	properties := rule["properties"].(map[string]any)
+	require.Equal(t, map[string]any{"type": "string", "minLength": 1, "maxLength": 255}, properties["ID"])
+	require.Equal(t, 1, properties["Status"].(map[string]any)["minLength"])
+	require.Equal(t, []any{"Enabled", "Disabled"}, properties["Status"].(map[string]any)["enum"])
+	require.Equal(t, map[string]any{"$ref": "#/$defs/Filter"}, properties["Filter"])
+}
+
+func TestSchemaExpiration(t *testing.T) {
+	expiration := definition(t, "Expiration")
+	properties := expiration["properties"].(map[string]any)
+	require.Equal(t, map[string]any{"type": "string", "anyOf": []any{map[string]any{"format": "date"}, map[string]any{"format": "date-time"}}}, properties["Date"])
+	require.Equal(t, map[string]any{"type": "integer", "minimum": 0}, properties["Days"])
+	require.Equal(t, []any{map[string]any{"not": map[string]any{"required": []string{"Date", "Days"}}}}, expiration["allOf"])
+}
+
+func TestSchemaAbortIncompleteMultipartUpload(t *testing.T) {
+	abort := definition(t, "AbortIncompleteMultipartUpload")
+	require.Equal(t, []string{"DaysAfterInitiation"}, abort["required"])
+	require.Equal(t, map[string]any{"type": "integer", "minimum": 0}, abort["properties"].(map[string]any)["DaysAfterInitiation"])
+}
+
+func TestSchemaTag(t *testing.T) {
+	properties := definition(t, "Tag")["properties"].(map[string]any)
+	require.Equal(t, map[string]any{"type": "string", "minLength": 1, "maxLength": 128}, properties["Key"])
+	require.Equal(t, map[string]any{"type": "string", "maxLength": 256}, properties["Value"])
+}
+
+func TestSchemaRequiredStrings(t *testing.T) {
+	// Like the validator, the schema rejects the empty strings of required fields
+	for _, name := range []string{"Transition", "NoncurrentVersionTransition"} {
+		properties := definition(t, name)["properties"].(map[string]any)
+		require.Equal(t, map[string]any{"type": "string", "minLength": 1}, properties["Bucket"], name)
+	}
+	rules := config.Schema()["properties"].(map[string]any)["Rules"].(map[string]any)
+	require.NotContains(t, rules, "minItems")
+}
+
+func TestSchemaDefinitions(t *testing.T) {
+	defs := config.Schema()["$defs"].(map[string]any)
+	names := []string{}
+	for name := range defs {
+		names = append(names, name)
+	}
+	require.ElementsMatch(t, []string{
+		"Rule", "Filter", "AndFilter", "Tag", "Expiration", "NoncurrentVersionExpiration",
+		"AbortIncompleteMultipartUpload", "Transition", "NoncurrentVersionTransition",
+	}, names)
+}
@@ -0,0 +1,637 @@
+// Package s3mem is an in-memory implementation of the S3 operations used to
+// apply a lifecycle configuration, to test the rule engine without a server.
+//
+// It follows the S3 semantics of versioned, versioning suspended and
+// unversioned buckets, delete markers and Object Lock, but does not check
+// credentials nor enforce the limits of the S3 API.
+package s3mem
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// NullVersionId is the version ID of the objects written while versioning is
+// not enabled on the bucket.
+const NullVersionId = "null"
+
+// defaultMaxKeys is the size of a listing page when MaxKeys is not set.
+const defaultMaxKeys = 1000
+
+// Client holds buckets in memory. It is safe for concurrent use.
+type Client struct {
+	// Now returns the date of the writes, time.Now when nil.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	nextVersion int
+}
+
+func New() *Client {
+	return &Client{buckets: map[string]*bucket{}}
+}
+
+type bucket struct {
+	versioning types.BucketVersioningStatus
+	objectLock bool
+	lifecycle  []types.LifecycleRule
+	// versions holds the history of each key, newest first.
+	versions map[string][]*version
+	uploads  []types.MultipartUpload
+}
+
+type version struct {
+	key          string
+	versionId    string
+	lastModified time.Time
+	deleteMarker bool
+	body         []byte
+	etag         string
+	contentType  *string
+	metadata     map[string]string
+	tags         map[string]string
+	retention    *types.ObjectLockRetention
+	legalHold    bool
+}
+
+func apiError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
+
+func (c *Client) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+func (c *Client) bucket(name *string) (*bucket, error) {
+	b, ok := c.buckets[aws.ToString(name)]
+	if !ok {
+		return nil, apiError("NoSuchBucket", "The specified bucket does not exist")
+	}
+	return b, nil
+}
+
+// newVersionId returns the ID of a new version, or the null version ID when
+// versioning is not enabled.
+func (c *Client) newVersionId(b *bucket) string {
+	if b.versioning != types.BucketVersioningStatusEnabled {
+		return NullVersionId
+	}
+	c.nextVersion++
+	return fmt.Sprintf("v%06d", c.nextVersion)
+}
+
+// add makes the version the latest one of its key. A null version replaces the
+// previous null version, if any.
+func (b *bucket) add(v *version) {
+	history := b.versions[v.key]
+	if v.versionId == NullVersionId {
+		history = removeVersion(history, NullVersionId)
+	}
+	b.versions[v.key] = append([]*version{v}, history...)
+}
+
+func removeVersion(history []*version, versionId string) []*version {
+	kept := make([]*version, 0, len(history))
+	for _, v := range history {
+		if v.versionId != versionId {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// find returns the given version of a key, or its latest one when versionId is
+// empty.
+func (b *bucket) find(key string, versionId *string) (*version, error) {
+	history := b.versions[key]
+	if aws.ToString(versionId) == "" {
+		if len(history) == 0 || history[0].deleteMarker {
+			return nil, apiError("NoSuchKey", "The specified key does not exist")
+		}
+		return history[0], nil
+	}
+	for _, v := range history {
+		if v.versionId == *versionId {
+			return v, nil
+		}
+	}
+	return nil, apiError("NoSuchVersion", "The specified version does not exist")
+}
+
+// protected returns the reason why the version cannot be permanently removed.
+func (v *version) protected(now time.Time, bypassGovernance bool) error {
+	if v.legalHold {
+		return apiError("AccessDenied", "The object is under a legal hold")
+	}
+	if r := v.retention; r != nil && r.RetainUntilDate != nil && r.RetainUntilDate.After(now) &&
+		!(r.Mode == types.ObjectLockRetentionModeGovernance && bypassGovernance) {
+		return apiError("AccessDenied", "The object is under a retention")
+	}
+	return nil
+}
+
+func (c *Client) CreateBucket(_ context.Context, input *s3.CreateBucketInput, _ ...func(*s3.Options)) (*s3.CreateBucketOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := aws.ToString(input.Bucket)
+	if _, ok := c.buckets[name]; ok {
+		return nil, apiError("BucketAlreadyOwnedByYou", "The bucket already exists")
+	}
+	b := &bucket{versions: map[string][]*version{}, objectLock: input.ObjectLockEnabledForBucket}
+	// Object Lock requires versioning
+	if b.objectLock {
+		b.versioning = types.BucketVersioningStatusEnabled
+	}
+	c.buckets[name] = b
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (c *Client) PutBucketVersioning(_ context.Context, input *s3.PutBucketVersioningInput, _ ...func(*s3.Options)) (*s3.PutBucketVersioningOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if input.VersioningConfiguration != nil {
+		b.versioning = input.VersioningConfiguration.Status
+	}
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (c *Client) GetBucketVersioning(_ context.Context, input *s3.GetBucketVersioningInput, _ ...func(*s3.Options)) (*s3.GetBucketVersioningOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetBucketVersioningOutput{Status: b.versioning}, nil
+}
+
+func (c *Client) PutBucketLifecycleConfiguration(_ context.Context, input *s3.PutBucketLifecycleConfigurationInput, _ ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	b.lifecycle = nil
+	if input.LifecycleConfiguration != nil {
+		b.lifecycle = input.LifecycleConfiguration.Rules
+	}
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (c *Client) GetBucketLifecycleConfiguration(_ context.Context, input *s3.GetBucketLifecycleConfigurationInput, _ ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.lifecycle) == 0 {
+		return nil, apiError("NoSuchLifecycleConfiguration", "The lifecycle configuration does not exist")
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: b.lifecycle}, nil
+}
+
+func (c *Client) GetObjectLockConfiguration(_ context.Context, input *s3.GetObjectLockConfigurationInput, _ ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !b.objectLock {
+		return nil, apiError("ObjectLockConfigurationNotFoundError", "Object Lock configuration does not exist for this bucket")
+	}
+	return &s3.GetObjectLockConfigurationOutput{
+		ObjectLockConfiguration: &types.ObjectLockConfiguration{ObjectLockEnabled: types.ObjectLockEnabledEnabled},
+	}, nil
+}
+
+func (c *Client) PutObject(_ context.Context, input *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body := []byte{}
+	if input.Body != nil {
+		var err error
+		if body, err = io.ReadAll(input.Body); err != nil {
+			return nil, err
+		}
+	}
+	tags := map[string]string{}
+	if input.Tagging != nil {
+		values, err := url.ParseQuery(*input.Tagging)
+		if err != nil {
+			return nil, apiError("InvalidArgument", err.Error())
+		}
+		for key := range values {
+			tags[key] = values.Get(key)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	sum := md5.Sum(body)
+	v := &version{
+		key:          aws.ToString(input.Key),
+		versionId:    c.newVersionId(b),
+		lastModified: c.now(),
+		body:         body,
+		etag:         `"` + hex.EncodeToString(sum[:]) + `"`,
+		contentType:  input.ContentType,
+		metadata:     input.Metadata,
+		tags:         tags,
+	}
+	if input.ObjectLockMode != "" && input.ObjectLockRetainUntilDate != nil {
+		v.retention = &types.ObjectLockRetention{Mode: types.ObjectLockRetentionMode(input.ObjectLockMode), RetainUntilDate: input.ObjectLockRetainUntilDate}
+	}
+	v.legalHold = input.ObjectLockLegalHoldStatus == types.ObjectLockLegalHoldStatusOn
+	b.add(v)
+
+	output := &s3.PutObjectOutput{ETag: aws.String(v.etag)}
+	if b.versioning != "" {
+		output.VersionId = aws.String(v.versionId)
+	}
+	return output, nil
+}
+
+func (c *Client) HeadObject(_ context.Context, input *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.find(aws.ToString(input.Key), input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.deleteMarker {
+		return nil, apiError("MethodNotAllowed", "The specified method is not allowed against a delete marker")
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: int64(len(v.body)),
+		ContentType:   v.contentType,
+		ETag:          aws.String(v.etag),
+		LastModified:  aws.Time(v.lastModified),
+		Metadata:      v.metadata,
+		VersionId:     aws.String(v.versionId),
+	}, nil
+}
+
+func (c *Client) GetObject(_ context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.find(aws.ToString(input.Key), input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.deleteMarker {
+		return nil, apiError("MethodNotAllowed", "The specified method is not allowed against a delete marker")
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(v.body)),
+		ContentLength: int64(len(v.body)),
+		ContentType:   v.contentType,
+		ETag:          aws.String(v.etag),
+		LastModified:  aws.Time(v.lastModified),
+		Metadata:      v.metadata,
+		VersionId:     aws.String(v.versionId),
+	}, nil
+}
+
+func (c *Client) GetObjectTagging(_ context.Context, input *s3.GetObjectTaggingInput, _ ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	v, err := b.find(aws.ToString(input.Key), input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(v.tags))
+	for key := range v.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tagSet := make([]types.Tag, 0, len(keys))
+	for _, key := range keys {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(key), Value: aws.String(v.tags[key])})
+	}
+	return &s3.GetObjectTaggingOutput{TagSet: tagSet, VersionId: aws.String(v.versionId)}, nil
+}
+
+func (c *Client) PutObjectRetention(_ context.Context, input *s3.PutObjectRetentionInput, _ ...func(*s3.Options)) (*s3.PutObjectRetentionOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, err := c.lockedVersion(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	v.retention = input.Retention
+	return &s3.PutObjectRetentionOutput{}, nil
+}
+
+func (c *Client) GetObjectRetention(_ context.Context, input *s3.GetObjectRetentionInput, _ ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, err := c.lockedVersion(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if v.retention == nil {
+		return nil, apiError("NoSuchObjectLockConfiguration", "The specified object does not have a ObjectLock configuration")
+	}
+	return &s3.GetObjectRetentionOutput{Retention: v.retention}, nil
+}
+
+func (c *Client) PutObjectLegalHold(_ context.Context, input *s3.PutObjectLegalHoldInput, _ ...func(*s3.Options)) (*s3.PutObjectLegalHoldOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, err := c.lockedVersion(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	v.legalHold = input.LegalHold != nil && input.LegalHold.Status == types.ObjectLockLegalHoldStatusOn
+	return &s3.PutObjectLegalHoldOutput{}, nil
+}
+
+func (c *Client) GetObjectLegalHold(_ context.Context, input *s3.GetObjectLegalHoldInput, _ ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, err := c.lockedVersion(input.Bucket, input.Key, input.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	status := types.ObjectLockLegalHoldStatusOff
+	if v.legalHold {
+		status = types.ObjectLockLegalHoldStatusOn
+	}
+	return &s3.GetObjectLegalHoldOutput{LegalHold: &types.ObjectLockLegalHold{Status: status}}, nil
+}
+
+// lockedVersion returns a version of a bucket on which Object Lock is enabled.
+func (c *Client) lockedVersion(bucketName, key, versionId *string) (*version, error) {
+	b, err := c.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if !b.objectLock {
+		return nil, apiError("InvalidRequest", "Bucket is missing Object Lock Configuration")
+	}
+	return b.find(aws.ToString(key), versionId)
+}
+
+func (c *Client) DeleteObject(_ context.Context, input *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := c.delete(b, types.ObjectIdentifier{Key: input.Key, VersionId: input.VersionId}, input.BypassGovernanceRetention)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.DeleteObjectOutput{DeleteMarker: deleted.DeleteMarker, VersionId: deleted.VersionId}, nil
+}
+
+func (c *Client) DeleteObjects(_ context.Context, input *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.DeleteObjectsOutput{}
+	if input.Delete == nil {
+		return output, nil
+	}
+	for _, object := range input.Delete.Objects {
+		deleted, err := c.delete(b, object, input.BypassGovernanceRetention)
+		if err != nil {
+			code, message := "InternalError", err.Error()
+			if apiErr, ok := err.(*smithy.GenericAPIError); ok {
+				code, message = apiErr.Code, apiErr.Message
+			}
+			output.Errors = append(output.Errors, types.Error{Key: object.Key, VersionId: object.VersionId, Code: aws.String(code), Message: aws.String(message)})
+			continue
+		}
+		output.Deleted = append(output.Deleted, deleted)
+	}
+	return output, nil
+}
+
+// delete permanently removes a version, or, without a version ID, expires the
+// latest version of the key like S3 does.
+func (c *Client) delete(b *bucket, object types.ObjectIdentifier, bypassGovernance bool) (types.DeletedObject, error) {
+	key := aws.ToString(object.Key)
+	deleted := types.DeletedObject{Key: object.Key, VersionId: object.VersionId}
+
+	if aws.ToString(object.VersionId) != "" {
+		v, err := b.find(key, object.VersionId)
+		if err != nil {
+			// Removing a version which does not exist succeeds
+			return deleted, nil
+		}
+		if err := v.protected(c.now(), bypassGovernance); err != nil {
+			return deleted, err
+		}
+		b.versions[key] = removeVersion(b.versions[key], v.versionId)
+		if len(b.versions[key]) == 0 {
+			delete(b.versions, key)
+		}
+		deleted.DeleteMarker = v.deleteMarker
+		return deleted, nil
+	}
+
+	if b.versioning == "" {
+		delete(b.versions, key)
+		return deleted, nil
+	}
+	marker := &version{key: key, versionId: c.newVersionId(b), lastModified: c.now(), deleteMarker: true}
+	b.add(marker)
+	deleted.DeleteMarker = true
+	deleted.DeleteMarkerVersionId = aws.String(marker.versionId)
+	return deleted, nil
+}
+
+func (c *Client) CreateMultipartUpload(_ context.Context, input *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	c.nextVersion++
+	uploadId := fmt.Sprintf("upload%06d", c.nextVersion)
+	b.uploads = append(b.uploads, types.MultipartUpload{Key: input.Key, UploadId: aws.String(uploadId), Initiated: aws.Time(c.now())})
+	return &s3.CreateMultipartUploadOutput{Bucket: input.Bucket, Key: input.Key, UploadId: aws.String(uploadId)}, nil
+}
+
+func (c *Client) AbortMultipartUpload(_ context.Context, input *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	for i, upload := range b.uploads {
+		if aws.ToString(upload.UploadId) == aws.ToString(input.UploadId) && aws.ToString(upload.Key) == aws.ToString(input.Key) {
+			b.uploads = append(b.uploads[:i], b.uploads[i+1:]...)
+			return &s3.AbortMultipartUploadOutput{}, nil
+		}
+	}
+	return nil, apiError("NoSuchUpload", "The specified multipart upload does not exist")
+}
+
+// ListMultipartUploads returns every upload in a single page.
+func (c *Client) ListMultipartUploads(_ context.Context, input *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	uploads := []types.MultipartUpload{}
+	for _, upload := range b.uploads {
+		if strings.HasPrefix(aws.ToString(upload.Key), aws.ToString(input.Prefix)) {
+			uploads = append(uploads, upload)
+		}
+	}
+	return &s3.ListMultipartUploadsOutput{Bucket: input.Bucket, Uploads: uploads}, nil
+}
+
+// sortedKeys returns the keys of the bucket starting with the prefix, in order.
+func (b *bucket) sortedKeys(prefix string) []string {
+	keys := make([]string, 0, len(b.versions))
+	for key := range b.versions {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func maxKeys(n int32) int {
+	if n <= 0 {
+		return defaultMaxKeys
+	}
+	return int(n)
+}
+
+// ListObjectVersions lists the versions by key, newest first, and is paginated
+// by KeyMarker and VersionIdMarker.
+func (c *Client) ListObjectVersions(_ context.Context, input *s3.ListObjectVersionsInput, _ ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*version{}
+	for _, key := range b.sortedKeys(aws.ToString(input.Prefix)) {
+		entries = append(entries, b.versions[key]...)
+	}
+	if input.KeyMarker != nil {
+		start := len(entries)
+		for i, v := range entries {
+			if v.key > *input.KeyMarker {
+				start = i
+				break
+			}
+			if v.key == *input.KeyMarker && aws.ToString(input.VersionIdMarker) != "" && v.versionId == *input.VersionIdMarker {
+				start = i + 1
+				break
+			}
+		}
+		entries = entries[start:]
+	}
+
+	output := &s3.ListObjectVersionsOutput{Name: input.Bucket, Prefix: input.Prefix, MaxKeys: int32(maxKeys(input.MaxKeys))}
+	if len(entries) > maxKeys(input.MaxKeys) {
+		entries = entries[:maxKeys(input.MaxKeys)]
+		last := entries[len(entries)-1]
+		output.IsTruncated = true
+		output.NextKeyMarker = aws.String(last.key)
+		output.NextVersionIdMarker = aws.String(last.versionId)
+	}
+	for _, v := range entries {
+		isLatest := b.versions[v.key][0] == v
+		if v.deleteMarker {
+			output.DeleteMarkers = append(output.DeleteMarkers, types.DeleteMarkerEntry{
+				Key: aws.String(v.key), VersionId: aws.String(v.versionId), IsLatest: isLatest, LastModified: aws.Time(v.lastModified),
+			})
+			continue
+		}
+		output.Versions = append(output.Versions, types.ObjectVersion{
+			Key: aws.String(v.key), VersionId: aws.String(v.versionId), IsLatest: isLatest, LastModified: aws.Time(v.lastModified),
+			Size: int64(len(v.body)), ETag: aws.String(v.etag),
+		})
+	}
+	return output, nil
+}
+
+// ListObjectsV2 lists the keys whose latest version is not a delete marker, and
+// is paginated by ContinuationToken, which is the last key returned.
+func (c *Client) ListObjectsV2(_ context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := c.bucket(input.Bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	after := aws.ToString(input.StartAfter)
+	if input.ContinuationToken != nil {
+		after = *input.ContinuationToken
+	}
+	output := &s3.ListObjectsV2Output{Name: input.Bucket, Prefix: input.Prefix, MaxKeys: int32(maxKeys(input.MaxKeys))}
+	for _, key := range b.sortedKeys(aws.ToString(input.Prefix)) {
+		latest := b.versions[key][0]
+		if key <= after || latest.deleteMarker {
+			continue
+		}
+		if len(output.Contents) == maxKeys(input.MaxKeys) {
+			output.IsTruncated = true
+			output.NextContinuationToken = output.Contents[len(output.Contents)-1].Key
+			break
+		}
+		output.Contents = append(output.Contents, types.Object{
+			Key: aws.String(key), LastModified: aws.Time(latest.lastModified), Size: int64(len(latest.body)), ETag: aws.String(latest.etag),
+		})
+	}
+	output.KeyCount = int32(len(output.Contents))
+	return output, nil
+}
@@ -0,0 +1,131 @@
+package s3mem_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/exoscale/sos-client-bucket-lifecycle/s3mem"
+)
+
+var ctx = context.TODO()
+
+func newBucket(t *testing.T, versioning types.BucketVersioningStatus) *s3mem.Client {
+	client := s3mem.New()
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	if versioning != "" {
+		_, err = client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{Bucket: aws.String("bucket"), VersioningConfiguration: &types.VersioningConfiguration{Status: versioning}})
+		require.NoError(t, err)
+	}
+	return client
+}
+
+func put(t *testing.T, client *s3mem.Client, key string) string {
+	output, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String("bucket"), Key: aws.String(key), Body: strings.NewReader("toto")})
+	require.NoError(t, err)
+	return aws.ToString(output.VersionId)
+}
+
+func TestVersionedBucket(t *testing.T) {
+	client := newBucket(t, types.BucketVersioningStatusEnabled)
+	v1 := put(t, client, "key1")
+	v2 := put(t, client, "key1")
+	deleted, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key1")})
+	require.NoError(t, err)
+	require.True(t, deleted.DeleteMarker)
+
+	output, err := client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(output.DeleteMarkers))
+	require.True(t, output.DeleteMarkers[0].IsLatest)
+	require.Equal(t, []string{v2, v1}, []string{*output.Versions[0].VersionId, *output.Versions[1].VersionId})
+	require.False(t, output.Versions[0].IsLatest)
+
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key1")})
+	require.Error(t, err)
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("key1"), VersionId: &v1})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), head.ContentLength)
+}
+
+func TestSuspendedBucketReplacesNullVersion(t *testing.T) {
+	client := newBucket(t, types.BucketVersioningStatusEnabled)
+	put(t, client, "key1")
+	_, err := client.PutBucketVersioning(ctx, &s3.PutBucketVersioningInput{Bucket: aws.String("bucket"), VersioningConfiguration: &types.VersioningConfiguration{Status: types.BucketVersioningStatusSuspended}})
+	require.NoError(t, err)
+	require.Equal(t, s3mem.NullVersionId, put(t, client, "key1"))
+	require.Equal(t, s3mem.NullVersionId, put(t, client, "key1"))
+
+	output, err := client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(output.Versions))
+}
+
+func TestUnversionedBucketDeletesForGood(t *testing.T) {
+	client := newBucket(t, "")
+	put(t, client, "key1")
+	_, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{Bucket: aws.String("bucket"), Delete: &types.Delete{Objects: []types.ObjectIdentifier{{Key: aws.String("key1")}}}})
+	require.NoError(t, err)
+
+	output, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("bucket")})
+	require.NoError(t, err)
+	require.Empty(t, output.Contents)
+}
+
+func TestListObjectVersionsPages(t *testing.T) {
+	client := newBucket(t, types.BucketVersioningStatusEnabled)
+	for _, key := range []string{"key1", "key2", "key2", "key2", "key3"} {
+		put(t, client, key)
+	}
+
+	paginator := s3.NewListObjectVersionsPaginator(client, &s3.ListObjectVersionsInput{Bucket: aws.String("bucket"), MaxKeys: 2})
+	pages, keys := 0, []string{}
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		require.NoError(t, err)
+		pages++
+		for _, version := range output.Versions {
+			keys = append(keys, *version.Key)
+		}
+	}
+	require.Equal(t, 3, pages)
+	require.Equal(t, []string{"key1", "key2", "key2", "key2", "key3"}, keys)
+}
+
+func TestListObjectsV2Pages(t *testing.T) {
+	client := newBucket(t, "")
+	for _, key := range []string{"key1", "key2", "key3"} {
+		put(t, client, key)
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String("bucket"), MaxKeys: 2})
+	keys := []string{}
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		require.NoError(t, err)
+		for _, object := range output.Contents {
+			keys = append(keys, *object.Key)
+		}
+	}
+	require.Equal(t, []string{"key1", "key2", "key3"}, keys)
+}
+
+func TestLegalHoldPreventsDeletion(t *testing.T) {
+	client := s3mem.New()
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("bucket"), ObjectLockEnabledForBucket: true})
+	require.NoError(t, err)
+	v1 := put(t, client, "key1")
+	_, err = client.PutObjectLegalHold(ctx, &s3.PutObjectLegalHoldInput{Bucket: aws.String("bucket"), Key: aws.String("key1"), VersionId: &v1, LegalHold: &types.ObjectLockLegalHold{Status: types.ObjectLockLegalHoldStatusOn}})
+	require.NoError(t, err)
+
+	output, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{Bucket: aws.String("bucket"), Delete: &types.Delete{Objects: []types.ObjectIdentifier{{Key: aws.String("key1"), VersionId: &v1}}}})
+	require.NoError(t, err)
+	require.Empty(t, output.Deleted)
+	require.Equal(t, "AccessDenied", *output.Errors[0].Code)
+}